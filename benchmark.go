@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// benchmark generates n synthetic log entries and reports how fast they are
+// parsed, and then parsed and inserted into a scratch database, isolating
+// local ingestion performance from S3 and network. Flags affecting ingestion,
+// like -commit-every or -time-ms, apply as usual.
+func benchmark(ctx context.Context, w io.Writer, args *runArgs, n int) error {
+	var raw int64
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	for i := range n {
+		line := syntheticEntry(i)
+		raw += int64(len(line))
+		if _, err := io.WriteString(gw, line); err != nil {
+			return err
+		}
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	fmt.Fprintf(w, "Generated %d entries: %s, %s compressed\n", n, formatSize(raw), formatSize(int64(len(data))))
+
+	if err := os.MkdirAll(tempDir(), 0777); err != nil {
+		return err
+	}
+	dbName := filepath.Join(tempDir(), "benchmark.db")
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		_ = os.Remove(dbName + suffix)
+	}
+	defer func() {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			_ = os.Remove(dbName + suffix)
+		}
+	}()
+	src := &logSource{
+		name: "benchmark",
+		open: func(context.Context, string) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+	}
+	ld, err := openDatabase(ctx, args, dbName, src)
+	if err != nil {
+		return err
+	}
+	defer ld.db.Close()
+
+	const key = "benchmark.log.gz"
+	var rows int
+	begin := time.Now()
+	if err := ld.decode(bytes.NewReader(data), key, 0, nil, func([]any, int) error { rows++; return nil }); err != nil {
+		return err
+	}
+	reportThroughput(w, "parse", rows, raw, time.Since(begin))
+
+	begin = time.Now()
+	if rows, err = ld.ingestLogFile(ctx, key); err != nil {
+		return err
+	}
+	reportThroughput(w, "parse+insert", rows, raw, time.Since(begin))
+	return ld.db.Close()
+}
+
+// reportThroughput writes throughput of a benchmark stage.
+func reportThroughput(w io.Writer, stage string, rows int, raw int64, d time.Duration) {
+	s := d.Seconds()
+	fmt.Fprintf(w, "%-13s %10s %12.0f rows/s %8.1f MB/s\n", stage+":", d.Round(time.Millisecond),
+		float64(rows)/s, float64(raw)/s/1e6)
+}
+
+// syntheticEntry returns the i-th synthetic log entry, including the trailing
+// newline. Entries vary in fields that usually vary in real logs.
+func syntheticEntry(i int) string {
+	t := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Millisecond)
+	status := [...]int{200, 200, 200, 200, 301, 404, 502}[i%7]
+	paths := [...]string{"/", "/api/v1/items?page=2", "/user/" + fmt.Sprint(i%1000) + "/profile", "/static/app.js"}
+	var b strings.Builder
+	fmt.Fprintf(&b, `https %s app/benchmark/0123456789abcdef 10.0.%d.%d:%d 10.1.0.%d:80 0.000 %.3f 0.000 %d %d %d %d `,
+		t.Format("2006-01-02T15:04:05.000000Z"), i%256, i%253+1, 1024+i%60000, i%4+1,
+		float64(i%500)/1000, status, status, 100+i%900, 1000+i%50000)
+	fmt.Fprintf(&b, `"GET https://example.com:443%s HTTP/1.1" "Mozilla/5.0 (benchmark)" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 `,
+		paths[i%len(paths)])
+	fmt.Fprintf(&b, `arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/tg/0123456789abcdef "Root=1-%08x-%024x" `, i, i)
+	fmt.Fprintf(&b, `"example.com" "arn:aws:acm:us-east-1:123456789012:certificate/x" 0 %s "forward" "-" "-" "10.1.0.%d:80" "%d" "-" "-" TID_%x`+"\n",
+		t.Format("2006-01-02T15:04:05.000000Z"), i%4+1, status, i)
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// columnAliases maps verbose log field names to shorter names used for the
+// database columns, unless -raw-columns is set.
+var columnAliases = map[string]string{
+	"request_processing_time":  "request_time",
+	"target_processing_time":   "target_time",
+	"response_processing_time": "response_time",
+	"elb_status_code":          "elb_status",
+	"target_status_code":       "target_status",
+	"target_status_code_list":  "target_status_list",
+	"matched_rule_priority":    "rule_priority",
+	"request_creation_time":    "request_created",
+}
+
+// tableColumns returns the set of the logs table column names.
+func tableColumns(ctx context.Context, db *sql.DB) (map[string]struct{}, error) {
+	names, err := tableColumnList(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		out[name] = struct{}{}
+	}
+	return out, nil
+}
+
+// tableColumnList returns the logs table column names in their order, or
+// nothing if the table does not exist.
+func tableColumnList(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM pragma_table_info('logs') ORDER BY cid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out = append(out, name)
+	}
+	return out, rows.Err()
+}
+
+// columnName returns the name of the logs table column holding the log field,
+// which depends on whether the database was created with -raw-columns.
+func columnName(ctx context.Context, db *sql.DB, field string) (string, error) {
+	cols, err := tableColumns(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	if alias, ok := columnAliases[field]; ok {
+		if _, ok := cols[alias]; ok {
+			return alias, nil
+		}
+	}
+	return field, nil
+}
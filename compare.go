@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/artyom/status"
+)
+
+// windowStats holds aggregates of a single time window, as compared by the
+// -compare mode.
+type windowStats struct {
+	requests int64
+	classes  map[int64]int64 // number of requests by status code class
+	latency  []float64       // target_processing_time percentiles, see comparePercentiles
+}
+
+var comparePercentiles = []float64{.5, .9, .99}
+
+// compare loads log files of two time windows into separate databases and
+// prints how their aggregates differ.
+func compare(ctx context.Context, args *runArgs, src *logSource, line *status.Line) error {
+	if err := os.MkdirAll(tempDir(), 0777); err != nil {
+		return err
+	}
+	var stats [2]*windowStats
+	for i, t := range [2]time.Time{args.window.From, args.compareTime} {
+		line.Printf("Fetching candidate log files list for %s", t.Format(timeLayout+" MST"))
+		keys, err := src.list(ctx, t)
+		if err != nil {
+			if context.Cause(ctx) == errMaxRuntime {
+				return errMaxRuntime
+			}
+			return err
+		}
+		dbName := filepath.Join(tempDir(), fmt.Sprintf("%s-%s.db", src.name, t.UTC().Format("20060102T1504Z")))
+		if stats[i], err = loadWindow(ctx, args, src, dbName, keys, line); err != nil {
+			return err
+		}
+	}
+	line.Print("")
+	printComparison(os.Stdout, [2]string{args.window.From.Format(timeLayout), args.compareTime.Format(timeLayout)},
+		stats, useColor(args.Color, os.Stdout))
+	return nil
+}
+
+// loadWindow loads log files identified by keys into the dbName database and
+// returns its aggregates.
+func loadWindow(ctx context.Context, args *runArgs, src *logSource, dbName string, keys []string, line *status.Line) (*windowStats, error) {
+	ld, err := openDatabase(ctx, args, dbName, src)
+	if err != nil {
+		return nil, err
+	}
+	defer ld.db.Close()
+	res, err := loadFiles(ctx, args, ld, src, keys, line)
+	if err != nil {
+		return nil, err
+	}
+	if res.timedOut {
+		return nil, errMaxRuntime
+	}
+	classes, err := statusClasses(ctx, ld.db)
+	if err != nil {
+		return nil, err
+	}
+	ws := &windowStats{classes: make(map[int64]int64)}
+	for _, c := range classes {
+		ws.classes[c.class] = c.count
+		ws.requests += c.count
+	}
+	if ws.latency, err = percentiles(ctx, ld.db, "target_processing_time", comparePercentiles...); err != nil {
+		return nil, err
+	}
+	return ws, ld.db.Close()
+}
+
+// printComparison writes aggregates of two windows side by side, along with
+// the relative change from the first window to the second one.
+func printComparison(w io.Writer, names [2]string, stats [2]*windowStats, color bool) {
+	const rowFormat = "%-16s %20s %20s %10s\n"
+	fmt.Fprintf(w, rowFormat, "", names[0], names[1], "change")
+	row := func(name string, a, b float64, format string) {
+		fmt.Fprintf(w, rowFormat, name, fmt.Sprintf(format, a), fmt.Sprintf(format, b), relChange(a, b))
+	}
+	row("requests", float64(stats[0].requests), float64(stats[1].requests), "%.0f")
+	for class := int64(1); class <= 5; class++ {
+		a, b := stats[0].classes[class], stats[1].classes[class]
+		if a == 0 && b == 0 {
+			continue
+		}
+		text := fmt.Sprintf(rowFormat, fmt.Sprintf("%dxx share", class),
+			fmt.Sprintf("%.1f%%", share(a, stats[0].requests)),
+			fmt.Sprintf("%.1f%%", share(b, stats[1].requests)),
+			relChange(share(a, stats[0].requests), share(b, stats[1].requests)))
+		if color {
+			text = colorizeClass(class, text[:len(text)-1]) + "\n"
+		}
+		io.WriteString(w, text)
+	}
+	if stats[0].latency == nil || stats[1].latency == nil {
+		return
+	}
+	for i, p := range comparePercentiles {
+		row(fmt.Sprintf("latency p%g", p*100), stats[0].latency[i], stats[1].latency[i], "%.3fs")
+	}
+}
+
+// share returns n as a percentage of total.
+func share(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// relChange formats the relative change from a to b as a signed percentage.
+func relChange(a, b float64) string {
+	if a == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*(b-a)/a)
+}
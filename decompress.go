@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+	"path"
+	"strings"
+)
+
+// decompressors map file name suffixes, such as ".lz4", to functions
+// returning readers of decompressed log files. They cover formats that some
+// teams re-compress archived logs with, and are registered by files built
+// with the respective tags: go build -tags snappy,lz4. Files with other names
+// are expected to be gzip-compressed, as AWS writes them.
+var decompressors = map[string]func(io.Reader) (io.Reader, error){}
+
+// decompressorFor returns the decompressor registered for the suffix of key,
+// or nil.
+func decompressorFor(key string) func(io.Reader) (io.Reader, error) {
+	for suffix, fn := range decompressors {
+		if strings.HasSuffix(key, suffix) {
+			return fn
+		}
+	}
+	return nil
+}
+
+// decompressedReader returns a reader of entries of the log file identified
+// by key, read from r. Files with names matching one of decompressors are
+// decompressed by it, others are expected to be gzip-compressed. Files
+// without the gzip header, such as those decompressed by a re-upload but
+// still named .log.gz, are read as plain text.
+func decompressedReader(r io.Reader, key string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if fn := decompressorFor(key); fn != nil {
+		return fn(br)
+	}
+	magic, err := br.Peek(2)
+	switch {
+	case err == nil && magic[0] == 0x1f && magic[1] == 0x8b:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return gr, nil
+	case err == nil:
+		log.Printf("%s: not gzip-compressed, reading as plain text", path.Base(key))
+	case err != io.EOF:
+		return nil, err
+	}
+	// files shorter than the header have no entries either way
+	return br, nil
+}
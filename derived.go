@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// derivedColumn is a database column computed from other fields of a log
+// entry during ingestion.
+type derivedColumn struct {
+	name    string
+	sqlType string
+	value   func(e *logEntry) any
+}
+
+// logEntry is a single parsed log entry.
+type logEntry struct {
+	key    string   // log file the entry comes from
+	fields []string // field values, in the order of loader.cols
+}
+
+// field returns the value of the field at index i, or "-" (the placeholder
+// ALB uses for missing values) if entry does not have it.
+func (e *logEntry) field(i int) string {
+	if i < 0 || i >= len(e.fields) {
+		return "-"
+	}
+	return e.fields[i]
+}
+
+// derivedColumns returns columns computed from log fields cols.
+func derivedColumns(cols []string) []derivedColumn {
+	request := slices.Index(cols, "request")
+	typ := slices.Index(cols, "type")
+	out := []derivedColumn{
+		{
+			// HTTP version of the request as a number: 1.0, 1.1, 2.0
+			name:    "protocol_version",
+			sqlType: "REAL",
+			value: func(e *logEntry) any {
+				s := e.field(request)
+				i := strings.LastIndexByte(s, ' ')
+				v, ok := strings.CutPrefix(s[i+1:], "HTTP/")
+				if !ok {
+					return nil
+				}
+				if x, err := strconv.ParseFloat(v, 64); err == nil {
+					return x
+				}
+				return nil
+			},
+		},
+		{
+			// gRPC requests are logged with the "grpcs" type
+			name:    "is_grpc",
+			sqlType: "INTEGER",
+			value: func(e *logEntry) any {
+				if e.field(typ) == "grpcs" {
+					return 1
+				}
+				return 0
+			},
+		},
+	}
+	actions := slices.Index(cols, "actions_executed")
+	for _, action := range loggedActions {
+		out = append(out, derivedColumn{
+			// whether the action is in the comma-separated list of
+			// actions taken, NULL for entries without the field
+			name:    "action_" + strings.ReplaceAll(action, "-", "_"),
+			sqlType: "INTEGER",
+			value: func(e *logEntry) any {
+				if actions == -1 || actions >= len(e.fields) {
+					return nil
+				}
+				if slices.Contains(strings.Split(e.fields[actions], ","), action) {
+					return 1
+				}
+				return 0
+			},
+		})
+	}
+	return out
+}
+
+// loggedActions are values of the actions_executed field, see
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#actions-taken
+var loggedActions = []string{"waf", "waf-failed", "authenticate", "redirect", "fixed-response", "forward"}
+
+// sourceKeyColumn holds the key or path of the log file an entry comes from,
+// added with -add-source-column.
+var sourceKeyColumn = derivedColumn{
+	name:    "source_key",
+	sqlType: "TEXT",
+	value:   func(e *logEntry) any { return e.key },
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// lineH2 is an HTTP/2 request that is not gRPC.
+const lineH2 = `h2 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 10.0.1.252:48160 10.0.0.66:9000 0.000 0.002 0.000 200 200 5 257 "GET https://10.0.2.105:773/ HTTP/2.0" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337327-72bd00b0343d75b906739c42" "-" "-" 1 2018-07-02T22:22:48.364000Z "redirect" "https://example.com:80/" "-" "10.0.0.66:9000" "200" "-" "-" TID_1234abcd5678ef90`
+
+func TestDerivedProtocol(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		line    string
+		version any
+		grpc    any
+	}{
+		{"http", lineHTTP, 1.1, 0},
+		{"h2", lineH2, 2.0, 0},
+		{"grpc", lineGRPC, 2.0, 1},
+		// requests ALB could not parse are logged as "- - -"
+		{"malformed", strings.Replace(lineHTTP, `"GET http://www.example.com:80/ HTTP/1.1"`, `"- - -"`, 1), nil, 0},
+		{"no version", strings.Replace(lineHTTP, ` HTTP/1.1"`, `"`, 1), nil, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rows := decodeRows(t, testLoader(), tc.line)
+			if v := rows[0]["protocol_version"]; v != tc.version {
+				t.Errorf("protocol_version: got %#v, want %#v", v, tc.version)
+			}
+			if v := rows[0]["is_grpc"]; v != tc.grpc {
+				t.Errorf("is_grpc: got %#v, want %#v", v, tc.grpc)
+			}
+		})
+	}
+}
+
+func TestDerivedWithoutFields(t *testing.T) {
+	// derived columns of a field list lacking their source fields, as with
+	// a custom -fields-file, must not panic
+	for _, d := range derivedColumns([]string{"time", "elb"}) {
+		switch d.name {
+		case "protocol_version":
+			if v := d.value(&logEntry{fields: []string{"x", "y"}}); v != nil {
+				t.Errorf("%s: got %#v, want nil", d.name, v)
+			}
+		case "is_grpc":
+			if v := d.value(&logEntry{fields: []string{"x", "y"}}); v != 0 {
+				t.Errorf("%s: got %#v, want 0", d.name, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/artyom/status"
+)
+
+// dryRun prints how many of the candidate log files would be loaded, their
+// total size, and the estimated number of rows in them. The estimate assumes
+// all files compress as well as the first one, which is read in full.
+//
+// Sizes of S3 objects are those reported when listing them, see objectStats;
+// only keys that were not listed, as with -keys-from, take a request each.
+func dryRun(ctx context.Context, args *runArgs, src *logSource, keys []string, line *status.Line) error {
+	selected := keys[:min(len(keys), args.MaxSamples)]
+	var total int64
+	for i, k := range selected {
+		line.Printf("Checking size of log file %d of %d", i+1, len(selected))
+		st, err := src.stat(ctx, k)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		total += st.size
+	}
+	line.Printf("Counting entries of %s", path.Base(selected[0]))
+	compressed, rows, err := countRows(ctx, src.open, selected[0])
+	if err != nil {
+		return fmt.Errorf("%s: %w", selected[0], err)
+	}
+	line.Print("")
+	fmt.Fprintf(os.Stdout, "Candidate log files: %d, would load %d (%s)\n", len(keys), len(selected), formatSize(total))
+	if rows == 0 || compressed == 0 {
+		fmt.Fprintf(os.Stdout, "Estimated rows: unknown, %s has no entries\n", path.Base(selected[0]))
+		return nil
+	}
+	perRow := float64(compressed) / float64(rows)
+	fmt.Fprintf(os.Stdout, "Estimated rows: %.0f (%.1f compressed bytes per row in %s)\n",
+		float64(total)/perRow, perRow, path.Base(selected[0]))
+	if args.SampleRate < 1 {
+		fmt.Fprintf(os.Stdout, "Estimated rows kept with -sample-rate %v: %.0f\n",
+			args.SampleRate, args.SampleRate*float64(total)/perRow)
+	}
+	return nil
+}
+
+// countRows reads the log file, decompressed the same way loading does,
+// returning its compressed size and the number of entries in it.
+func countRows(ctx context.Context, open openFunc, key string) (compressed int64, rows int, err error) {
+	rc, err := open(ctx, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rc.Close()
+	cr := &countingReader{r: rc}
+	text, err := decompressedReader(cr, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	sc := bufio.NewScanner(text)
+	sc.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for sc.Scan() {
+		rows++
+	}
+	if err := sc.Err(); err != nil {
+		return 0, 0, err
+	}
+	return cr.n, rows, nil
+}
+
+// countingReader counts bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
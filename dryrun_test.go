@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artyom/status"
+)
+
+func TestDryRunListedSizes(t *testing.T) {
+	ref := time.Now().UTC().Add(-time.Hour).Truncate(time.Minute)
+	body, err := io.ReadAll(gzipLines(t, lineHTTP, lineGRPC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objs []fakeObject
+	for i := range 5 {
+		objs = append(objs, fakeObject{
+			key:      testKey(ref, i),
+			modified: ref.Add(time.Minute),
+			size:     int64(len(body)),
+			body:     body,
+		})
+	}
+	srv, heads := fakeS3Server(t, objs)
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	args := &runArgs{
+		Bucket:     "bucket",
+		Account:    "123456789012",
+		AccessKey:  "key",
+		SecretKey:  "secret",
+		MaxSamples: 10,
+		window:     Window{From: ref, To: ref.Add(windowSize)},
+	}
+	ctx := context.Background()
+	src, err := newLogSource(ctx, args, "lb@us-west-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := src.list(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(objs) {
+		t.Fatalf("listed %d keys, want %d", len(keys), len(objs))
+	}
+	stdout := os.Stdout
+	defer func() { os.Stdout = stdout }()
+	if os.Stdout, err = os.Open(os.DevNull); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Stdout.Close()
+	if err := dryRun(ctx, args, src, keys, new(status.Line)); err != nil {
+		t.Fatal(err)
+	}
+	if n := heads.Load(); n != 0 {
+		t.Errorf("made %d HeadObject requests for listed files", n)
+	}
+	// keys that were not listed, as with -keys-from, are looked up
+	st, err := src.stat(ctx, objs[0].key+"x")
+	if err == nil {
+		t.Errorf("got %+v for a missing object", st)
+	}
+	if n := heads.Load(); n != 1 {
+		t.Errorf("made %d HeadObject requests for a key that was not listed, want 1", n)
+	}
+}
+
+func TestCountRows(t *testing.T) {
+	dir := t.TempDir()
+	lines := []string{lineHTTP, lineGRPC, lineIPv6}
+	gz, err := io.ReadAll(gzipLines(t, lines...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte(strings.Join(lines, "\n") + "\n")
+	// a decompressor for a suffix that isn't gzip, registered the way
+	// builds with the snappy and lz4 tags do
+	decompressors[".rot13"] = func(r io.Reader) (io.Reader, error) {
+		b, err := io.ReadAll(r)
+		return strings.NewReader(rot13(string(b))), err
+	}
+	defer delete(decompressors, ".rot13")
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"x.log.gz", gz},
+		// decompressed by a re-upload, see decompressedReader
+		{"plain.log.gz", plain},
+		{"x.log.rot13", []byte(rot13(string(plain)))},
+	} {
+		name := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(name, tc.data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		compressed, rows, err := countRows(context.Background(), openLocalFile, name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if compressed != int64(len(tc.data)) || rows != len(lines) {
+			t.Errorf("%s: got %d bytes and %d rows, want %d bytes and %d rows", tc.name, compressed, rows, len(tc.data), len(lines))
+		}
+	}
+}
+
+func rot13(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+13)%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+13)%26
+		}
+		return r
+	}, s)
+}
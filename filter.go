@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// rowFilter reports whether a log entry should be loaded into the database.
+type rowFilter func(e *logEntry) bool
+
+// excludeHealthChecks returns a filter dropping requests made by the load
+// balancer target health checks.
+func excludeHealthChecks(cols []string) rowFilter {
+	userAgent := slices.Index(cols, "user_agent")
+	return func(e *logEntry) bool {
+		return !strings.HasPrefix(e.field(userAgent), "ELB-HealthChecker/")
+	}
+}
+
+// statusFilter returns a filter only keeping requests with ELB status codes
+// in the [lo, hi] range; zero bounds are not checked. Requests without the
+// status code, like those cut short by the client, are dropped.
+func statusFilter(cols []string, lo, hi int) rowFilter {
+	status := slices.Index(cols, "elb_status_code")
+	return func(e *logEntry) bool {
+		code, err := strconv.Atoi(e.field(status))
+		return err == nil && (lo == 0 || code >= lo) && (hi == 0 || code <= hi)
+	}
+}
+
+// targetFilter returns a filter only keeping requests forwarded to targets
+// within the prefix. Requests not forwarded to any target, like those
+// answered by a fixed-response action, are dropped.
+func targetFilter(cols []string, prefix netip.Prefix) rowFilter {
+	target := slices.Index(cols, "target_port")
+	return func(e *logEntry) bool {
+		ap, err := netip.ParseAddrPort(e.field(target))
+		return err == nil && prefix.Contains(ap.Addr().Unmap())
+	}
+}
+
+// sslProtocolFilter returns a filter only keeping requests made over one of
+// the TLS protocols, such as TLSv1.2. Unencrypted requests, logged with the
+// "-" protocol, are dropped.
+func sslProtocolFilter(cols []string, protocols []string) rowFilter {
+	protocol := slices.Index(cols, "ssl_protocol")
+	for i := range protocols {
+		protocols[i] = strings.TrimSpace(protocols[i])
+	}
+	return func(e *logEntry) bool {
+		p := e.field(protocol)
+		return p != "-" && slices.Contains(protocols, p)
+	}
+}
+
+// parseTarget parses the -target value, which is either a single IP address
+// or a CIDR range.
+func parseTarget(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("-target must be an IP address or a CIDR range like 10.0.1.0/24, got %q", s)
+	}
+	return p.Masked(), nil
+}
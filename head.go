@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/artyom/status"
+)
+
+// printHead parses candidate log files identified by keys, in order, until
+// it gets args.Head entries passing filters, and writes them to w, one
+// "column: value" line per column, entries separated by empty lines. Nothing
+// is loaded: the database is in memory, only used to set up the loader.
+func printHead(ctx context.Context, w io.Writer, args *runArgs, src *logSource, keys []string, line *status.Line) error {
+	ld, err := openDatabase(ctx, args, ":memory:", src)
+	if err != nil {
+		return err
+	}
+	defer ld.db.Close()
+	var width int
+	for _, c := range ld.columns {
+		width = max(width, len(c))
+	}
+	errEnough := errors.New("enough entries")
+	var n int
+	for _, key := range keys {
+		err := ld.parse(ctx, key, 0, func(row []any, lineNo int) error {
+			if n == 0 {
+				line.Print("")
+			} else {
+				fmt.Fprintln(w)
+			}
+			n++
+			fmt.Fprintf(w, "%*s: %s:%d\n", width, "(entry)", path.Base(key), lineNo)
+			for i, v := range row {
+				fmt.Fprintf(w, "%*s: %s\n", width, ld.columns[i], formatValue(v, args.FloatPrecision))
+			}
+			if n == args.Head {
+				return errEnough
+			}
+			return nil
+		})
+		if err == errEnough {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("parsing %q: %w", key, err)
+		}
+	}
+	line.Print("")
+	if n == 0 {
+		return fmt.Errorf("%w: none of %d log files have entries passing filters", ErrNoCandidates, len(keys))
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	alb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var errHealthcheckFailed = errors.New("healthcheck failed")
+
+// healthcheck verifies that the configuration and permissions allow loading
+// logs of the load balancer, by making the same API calls as a regular run,
+// bypassing the metadata cache: with -bucket, the load balancer isn't looked
+// up, and -prefix and -account apply the same way. Each step is reported to
+// w along with the permission it exercises. Steps depend on the previous ones, so checking
+// stops at the first failure.
+func healthcheck(ctx context.Context, w io.Writer, args *runArgs, albName string) error {
+	report := func(step, permission string, err error) bool {
+		status := "PASS"
+		if err != nil {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%s  %s", status, step)
+		if permission != "" {
+			fmt.Fprintf(w, " (%s)", permission)
+		}
+		fmt.Fprintln(w)
+		if err != nil {
+			fmt.Fprintf(w, "      %v\n", err)
+		}
+		return err == nil
+	}
+
+	cfg, err := awsConfig(ctx, args, albName)
+	if err == nil {
+		_, err = cfg.Credentials.Retrieve(ctx)
+	}
+	if !report("load AWS configuration and credentials", "", err) {
+		return errHealthcheckFailed
+	}
+
+	var meta *metadata
+	if args.Bucket != "" {
+		// same as a regular run, which doesn't look up the load
+		// balancer either
+		meta, err = metadataFromFlags(args, albName)
+		if !report("take logs bucket from -bucket", "", err) {
+			return errHealthcheckFailed
+		}
+	} else if meta, err = discoverLogsLocation(ctx, cfg, albName, report); err != nil {
+		return err
+	} else if args.Account != "" {
+		meta.Account = args.Account
+	}
+	if meta.Region == "" {
+		if meta.Region = cfg.Region; meta.Region == "" {
+			report("figure out the load balancer region", "", errors.New("set AWS_REGION or configure region for the profile"))
+			return errHealthcheckFailed
+		}
+	}
+
+	var owner *string
+	if args.BucketOwner != "" {
+		owner = &args.BucketOwner
+	}
+	s3Client := newS3Client(cfg)
+	// any log file will do, not necessarily a recent one
+	prefix := listedPrefix(args, meta)
+	list, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:              &meta.Bucket,
+		Prefix:              &prefix,
+		MaxKeys:             aws.Int32(1),
+		ExpectedBucketOwner: owner,
+	})
+	if err == nil && len(list.Contents) == 0 {
+		err = fmt.Errorf("no objects in bucket %q under prefix %q", meta.Bucket, prefix)
+	}
+	if !report(fmt.Sprintf("list log files in bucket %s", meta.Bucket), "s3:ListBucket", err) {
+		return errHealthcheckFailed
+	}
+
+	// reading the first byte needs the same permissions as downloading
+	// the whole file: unlike HeadObject, it makes S3 decrypt objects
+	// encrypted with KMS keys
+	key := aws.ToString(list.Contents[0].Key)
+	obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:               &meta.Bucket,
+		Key:                  &key,
+		Range:                aws.String("bytes=0-0"),
+		ExpectedBucketOwner:  owner,
+		SSECustomerAlgorithm: args.sseKey.algorithm(),
+		SSECustomerKey:       args.sseKey.keyValue(),
+		SSECustomerKeyMD5:    args.sseKey.keyMD5(),
+	})
+	if err == nil {
+		_, err = io.Copy(io.Discard, obj.Body)
+		obj.Body.Close()
+	}
+	if !report("read log file "+path.Base(key), "s3:GetObject, and kms:Decrypt for SSE-KMS", err) {
+		return errHealthcheckFailed
+	}
+	return nil
+}
+
+// discoverLogsLocation looks up the load balancer and its access logs
+// settings, reporting each step, see healthcheck. It returns
+// errHealthcheckFailed if any step fails.
+func discoverLogsLocation(ctx context.Context, cfg aws.Config, albName string, report func(step, permission string, err error) bool) (*metadata, error) {
+	albClient := alb.NewFromConfig(cfg)
+	name, _ := splitRegion(albName)
+	input := &alb.DescribeLoadBalancersInput{Names: []string{name}}
+	if isLoadBalancerARN(albName) {
+		input = &alb.DescribeLoadBalancersInput{LoadBalancerArns: []string{albName}}
+	}
+	var albARN string
+	res, err := albClient.DescribeLoadBalancers(ctx, input)
+	if err == nil {
+		if len(res.LoadBalancers) == 0 {
+			err = fmt.Errorf("load balancer %q not found", albName)
+		} else {
+			albARN = aws.ToString(res.LoadBalancers[0].LoadBalancerArn)
+		}
+	}
+	if !report("describe load balancer", "elasticloadbalancing:DescribeLoadBalancers", err) {
+		return nil, errHealthcheckFailed
+	}
+
+	meta := &metadata{ARN: albARN}
+	attrs, err := albClient.DescribeLoadBalancerAttributes(ctx, &alb.DescribeLoadBalancerAttributesInput{
+		LoadBalancerArn: &albARN,
+	})
+	if err == nil {
+		meta.Bucket, meta.Prefix, err = logsLocation(attrs.Attributes)
+	}
+	if !report("read access logs settings", "elasticloadbalancing:DescribeLoadBalancerAttributes", err) {
+		return nil, errHealthcheckFailed
+	}
+	if meta.Account, meta.Region, err = accountAndRegion(albARN); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
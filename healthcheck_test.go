@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHealthcheckBucketFlags(t *testing.T) {
+	ref := mustTime(t, "2024-01-02T10:05:00Z")
+	objs := []fakeObject{
+		{key: testKey(ref, 1), modified: ref, size: 10, body: []byte("standard")},
+		{key: "moved/123456789012/2024/01/02/x.log.gz", modified: ref, size: 10, body: []byte("moved")},
+	}
+	s3srv, _ := fakeS3Server(t, objs)
+	u, err := url.Parse(s3srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// requests are recorded on the way to the fake bucket; SDK versions
+	// differ in whether bucket paths end with a slash
+	var mu sync.Mutex
+	var requests []string
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, strings.Join(strings.Fields(r.Method+" "+strings.TrimSuffix(r.URL.Path, "/")+" "+r.URL.Query().Get("prefix")+" "+r.Header.Get("Range")), " "))
+		mu.Unlock()
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	for _, tc := range []struct {
+		name string
+		args runArgs
+		want []string
+	}{
+		{"standard prefix", runArgs{Bucket: "logs", Account: "123456789012"}, []string{
+			"GET /logs AWSLogs/123456789012/elasticloadbalancing/us-west-2/",
+			"GET /logs/" + testKey(ref, 1) + " bytes=0-0",
+		}},
+		{"custom prefix", runArgs{Bucket: "moved-logs", Account: "123456789012", Prefix: "moved/{account}/%Y/%m/%d"}, []string{
+			"GET /moved-logs moved/123456789012/",
+			"GET /moved-logs/moved/123456789012/2024/01/02/x.log.gz bytes=0-0",
+		}},
+	} {
+		requests = nil
+		tc.args.AccessKey, tc.args.SecretKey = "key", "secret"
+		var out bytes.Buffer
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := healthcheck(ctx, &out, &tc.args, "lb@us-west-2")
+		cancel()
+		if err != nil {
+			t.Errorf("%s: %v\n%s", tc.name, err, &out)
+			continue
+		}
+		if strings.Contains(out.String(), "FAIL") || strings.Contains(out.String(), "describe") {
+			t.Errorf("%s: unexpected report:\n%s", tc.name, &out)
+		}
+		mu.Lock()
+		got := strings.Join(requests, "\n")
+		mu.Unlock()
+		if want := strings.Join(tc.want, "\n"); got != want {
+			t.Errorf("%s: got requests\n%s\nwant\n%s", tc.name, got, want)
+		}
+	}
+}
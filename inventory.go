@@ -0,0 +1,164 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// inventoryManifest is the manifest.json file of an S3 Inventory report, see
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/storage-inventory-location.html
+type inventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"` // bucket ARN
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// inventoryKeys works like candidateKeys, but finds log files in the S3
+// Inventory report of the logs bucket instead of listing it. manifestURL is
+// the s3:// URL of the report manifest.json file.
+func inventoryKeys(ctx context.Context, client *s3.Client, manifestURL, bucket string, prefixes []string, refTime time.Time, opts listOptions) ([]string, error) {
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	var manifest inventoryManifest
+	if err := readS3JSON(ctx, client, u.Host, strings.TrimPrefix(u.Path, "/"), &manifest); err != nil {
+		return nil, fmt.Errorf("reading inventory manifest: %w", err)
+	}
+	if manifest.SourceBucket != bucket {
+		return nil, fmt.Errorf("inventory report is for bucket %q, logs are in %q", manifest.SourceBucket, bucket)
+	}
+	if manifest.FileFormat != "CSV" {
+		return nil, fmt.Errorf("inventory report format is %s, only CSV is supported", manifest.FileFormat)
+	}
+	schema := strings.Split(manifest.FileSchema, ",")
+	for i := range schema {
+		schema[i] = strings.TrimSpace(schema[i])
+	}
+	keyIdx := slices.Index(schema, "Key")
+	sizeIdx := slices.Index(schema, "Size")
+	etagIdx := slices.Index(schema, "ETag")
+	modIdx := slices.Index(schema, "LastModifiedDate")
+	if keyIdx == -1 || modIdx == -1 {
+		return nil, errors.New("inventory report must include the LastModifiedDate field")
+	}
+	destBucket := manifest.DestinationBucket[strings.LastIndexByte(manifest.DestinationBucket, ':')+1:]
+
+	from := refTime.Truncate(time.Second)
+	to := opts.windowEnd(from)
+	groups := make([][]string, len(prefixes))
+	for _, f := range manifest.Files {
+		err := readInventoryFile(ctx, client, destBucket, f.Key, func(record []string) error {
+			if len(record) != len(schema) {
+				return fmt.Errorf("inventory record has %d fields, schema has %d", len(record), len(schema))
+			}
+			// keys are URL-encoded in CSV reports
+			key, err := url.QueryUnescape(record[keyIdx])
+			if err != nil {
+				return err
+			}
+			i := slices.IndexFunc(prefixes, func(p string) bool { return strings.HasPrefix(key, p+"/") })
+			if i == -1 {
+				return nil
+			}
+			t, err := time.Parse(time.RFC3339, record[modIdx])
+			if err != nil {
+				return err
+			}
+			if !opts.delivered(key, t, from, to) {
+				return nil
+			}
+			size := opts.minSize // size is only checked if reported
+			if sizeIdx != -1 {
+				if size, err = strconv.ParseInt(record[sizeIdx], 10, 64); err != nil {
+					return err
+				}
+			}
+			if !opts.accept(key, prefixes[i], size) {
+				return nil
+			}
+			if sizeIdx != -1 {
+				st := fileStat{size: size}
+				if etagIdx != -1 {
+					// reports have ETags without quotes
+					// the S3 API wraps them in
+					st.etag = record[etagIdx]
+					if !strings.HasPrefix(st.etag, `"`) {
+						st.etag = `"` + st.etag + `"`
+					}
+				}
+				opts.stats.add(key, st)
+			}
+			groups[i] = append(groups[i], key)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading inventory file %q: %w", f.Key, err)
+		}
+	}
+	// inventory files are not sorted, while listing returns keys in
+	// lexical order: the limits only apply once all keys are known, so
+	// that they keep the same keys as listing would
+	for i, g := range groups {
+		slices.Sort(g)
+		if opts.perPrefix > 0 && len(g) > opts.perPrefix {
+			groups[i] = g[:opts.perPrefix]
+		}
+	}
+	return interleaveKeys(groups, bucket, prefixes, opts)
+}
+
+// readS3JSON decodes the JSON object stored in S3 into v.
+func readS3JSON(ctx context.Context, client *s3.Client, bucket, key string, v any) error {
+	obj, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+	return json.NewDecoder(obj.Body).Decode(v)
+}
+
+// readInventoryFile calls fn for each record of the gzip-compressed CSV
+// inventory file.
+func readInventoryFile(ctx context.Context, client *s3.Client, bucket, key string, fn func(record []string) error) error {
+	obj, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+	gr, err := gzip.NewReader(obj.Body)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	r := csv.NewReader(gr)
+	r.ReuseRecord = true
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
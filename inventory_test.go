@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInventoryKeysLimits(t *testing.T) {
+	ref := mustTime(t, "2024-01-02T10:05:00Z")
+	var keys []string
+	for i := range 6 {
+		keys = append(keys, testKey(ref, i))
+	}
+	// records of the report are in no particular order, split across files
+	record := func(key string) string {
+		return fmt.Sprintf("\"logs\",\"%s\",\"1000\",\"%s\"\n", key, ref.Add(time.Minute).Format(time.RFC3339))
+	}
+	files := []string{
+		record(keys[4]) + record(keys[1]) + record(keys[5]),
+		record(keys[3]) + record(keys[0]) + record(keys[2]),
+	}
+	manifest := map[string]any{
+		"sourceBucket":      "logs",
+		"destinationBucket": "arn:aws:s3:::inventory",
+		"fileFormat":        "CSV",
+		"fileSchema":        "Bucket, Key, Size, LastModifiedDate",
+	}
+	objs := []fakeObject{{key: "report/manifest.json"}}
+	var refs []map[string]string
+	for i, s := range files {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(s))
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		key := fmt.Sprintf("report/data/%d.csv.gz", i)
+		objs = append(objs, fakeObject{key: key, body: buf.Bytes()})
+		refs = append(refs, map[string]string{"key": key})
+	}
+	manifest["files"] = refs
+	var err error
+	if objs[0].body, err = json.Marshal(manifest); err != nil {
+		t.Fatal(err)
+	}
+	client := fakeS3(t, objs)
+
+	for _, tc := range []struct {
+		name string
+		opts listOptions
+		want []string
+	}{
+		{"all", listOptions{}, keys},
+		// the lexically first keys, as listing would return
+		{"limit", listOptions{limit: 3}, keys[:3]},
+		{"per prefix", listOptions{perPrefix: 2}, keys[:2]},
+		{"both", listOptions{limit: 1, perPrefix: 2}, keys[:1]},
+	} {
+		tc.opts.window = windowSize
+		prefixes := windowPrefixes(ref, tc.opts.windowEnd(ref), "", "123456789012", "us-west-2")
+		got, err := inventoryKeys(context.Background(), client, "s3://inventory/report/manifest.json", "logs", prefixes, ref, tc.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got\n\t%q\nwant\n\t%q", tc.name, got, tc.want)
+		}
+	}
+}
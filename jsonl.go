@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// rowEncoder writes parsed rows as JSON objects, one per line, keyed by
+// table column names. It is safe for concurrent use.
+//
+// Rows are encoded as they are parsed, but only written once they are
+// committed to the database, see pendingOutput, so that a file failing to
+// load midway, or resumed later, doesn't leave extra or duplicate rows.
+type rowEncoder struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	columns []string
+	numeric []bool // whether columns have numeric types
+}
+
+func newRowEncoder(w io.Writer, columns []string, numeric []bool) *rowEncoder {
+	return &rowEncoder{w: bufio.NewWriter(w), columns: columns, numeric: numeric}
+}
+
+// appendRow appends a single row holding values for e.columns, in the same
+// order, to b.
+func (e *rowEncoder) appendRow(b []byte, row []any) ([]byte, error) {
+	b = append(b, '{')
+	for i, v := range row {
+		if i != 0 {
+			b = append(b, ',')
+		}
+		name, _ := json.Marshal(e.columns[i])
+		b = append(b, name...)
+		b = append(b, ':')
+		if s, ok := v.(string); ok && e.numeric[i] {
+			// same as SQLite does for columns with numeric
+			// affinity: strings that look like numbers are
+			// stored as numbers, others are kept as is
+			if x, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(x, 0) && !math.IsNaN(x) {
+				v = x
+			}
+		}
+		val, err := json.Marshal(v)
+		if err != nil {
+			return b, err
+		}
+		b = append(b, val...)
+	}
+	return append(b, '}', '\n'), nil
+}
+
+// write writes rows encoded by appendRow.
+func (e *rowEncoder) write(rows []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(rows)
+	return err
+}
+
+// flush writes any buffered rows to the underlying writer.
+func (e *rowEncoder) flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.w.Flush()
+}
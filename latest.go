@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// latestKeys returns keys of the most recently delivered log files, newest
+// first, for -latest. Only the newest date prefix under base, as found by
+// descending its year/month/day hierarchy, is listed, so it works however
+// long ago logs stopped coming.
+func latestKeys(ctx context.Context, client *s3.Client, bucket string, owner *string, base string, opts listOptions) ([]string, error) {
+	dayPrefix := base
+	for range 3 { // year, month, day
+		next, err := lastSubPrefix(ctx, client, bucket, owner, dayPrefix+"/")
+		if err != nil {
+			return nil, err
+		}
+		if next == "" {
+			return nil, fmt.Errorf("%w: bucket %q, prefix %q has no dated log files", ErrNoCandidates, bucket, base)
+		}
+		dayPrefix = strings.TrimSuffix(next, "/")
+	}
+	type object struct {
+		key      string
+		modified time.Time
+	}
+	var objects []object
+	p := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:              &bucket,
+		Prefix:              aws.String(dayPrefix + "/"),
+		ExpectedBucketOwner: owner,
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			if obj.LastModified == nil || obj.Key == nil || !opts.accept(*obj.Key, dayPrefix, aws.ToInt64(obj.Size)) {
+				continue
+			}
+			opts.stats.add(*obj.Key, fileStat{size: aws.ToInt64(obj.Size), etag: aws.ToString(obj.ETag)})
+			objects = append(objects, object{key: *obj.Key, modified: *obj.LastModified})
+		}
+	}
+	slices.SortStableFunc(objects, func(a, b object) int { return b.modified.Compare(a.modified) })
+	if opts.limit > 0 && len(objects) > opts.limit {
+		objects = objects[:opts.limit]
+	}
+	keys := make([]string, len(objects))
+	for i, o := range objects {
+		keys[i] = o.key
+	}
+	return interleaveKeys([][]string{keys}, bucket, []string{dayPrefix}, opts)
+}
+
+// lastSubPrefix returns the lexicographically greatest prefix directly under
+// prefix, including its trailing slash, or an empty string if there is none.
+func lastSubPrefix(ctx context.Context, client *s3.Client, bucket string, owner *string, prefix string) (string, error) {
+	var last string
+	p := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:              &bucket,
+		Prefix:              &prefix,
+		Delimiter:           aws.String("/"),
+		ExpectedBucketOwner: owner,
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, cp := range page.CommonPrefixes {
+			if s := aws.ToString(cp.Prefix); s > last {
+				last = s
+			}
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeObject is an object of the bucket served by fakeS3.
+type fakeObject struct {
+	key      string
+	modified time.Time
+	size     int64
+	body     []byte // if not nil, served by GetObject
+}
+
+// fakeS3 returns a client of a fake S3 bucket holding objs, see
+// fakeS3Server.
+func fakeS3(t *testing.T, objs []fakeObject) *s3.Client {
+	srv, _ := fakeS3Server(t, objs)
+	return s3.New(s3.Options{
+		Region:       "us-west-2",
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+}
+
+// fakeS3Server starts the server of a fake S3 bucket holding objs, which
+// serves ListObjectsV2 requests two keys per page, so pagination is
+// exercised too, GetObject and HeadObject requests. HeadObject requests are
+// counted in heads.
+func fakeS3Server(t *testing.T, objs []fakeObject) (srv *httptest.Server, heads *atomic.Int32) {
+	t.Helper()
+	heads = new(atomic.Int32)
+	objs = slices.Clone(objs)
+	slices.SortFunc(objs, func(a, b fakeObject) int { return strings.Compare(a.key, b.key) })
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+	}
+	type result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+		}
+		if q.Get("list-type") != "2" {
+			_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+			i := slices.IndexFunc(objs, func(o fakeObject) bool { return o.key == key })
+			if i == -1 || objs[i].body == nil {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, objs[i].size))
+			w.Header().Set("Content-Length", strconv.Itoa(len(objs[i].body)))
+			if r.Method == http.MethodHead {
+				return
+			}
+			w.Write(objs[i].body)
+			return
+		}
+		after := q.Get("start-after")
+		if tok := q.Get("continuation-token"); tok != "" {
+			after = tok
+		}
+		var res result
+		for _, o := range objs {
+			if !strings.HasPrefix(o.key, q.Get("prefix")) || o.key <= after {
+				continue
+			}
+			if len(res.Contents) == 2 {
+				res.IsTruncated = true
+				res.NextContinuationToken = res.Contents[1].Key
+				break
+			}
+			res.Contents = append(res.Contents, content{
+				Key:          o.key,
+				LastModified: o.modified.UTC().Format("2006-01-02T15:04:05.000Z"),
+				ETag:         fmt.Sprintf(`"%x"`, o.size),
+				Size:         o.size,
+			})
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		if err := xml.NewEncoder(w).Encode(res); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, heads
+}
+
+// testKey returns the key of a log file the load balancer wrote at t.
+func testKey(t time.Time, node int) string {
+	return fullS3prefix(t, "", "123456789012", "us-west-2") + fmt.Sprintf(
+		"/123456789012_elasticloadbalancing_us-west-2_app.lb.0123456789abcdef_%s_10.0.0.%d_%dabc.log.gz",
+		t.UTC().Format("20060102T1504Z"), node, node)
+}
+
+func mustTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	v, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestCandidateKeysBoundaries(t *testing.T) {
+	// each object is delivered a few minutes after the load balancer writes
+	// it, so keys and LastModified times differ
+	var node int
+	obj := func(written, delivered string) fakeObject {
+		node++
+		return fakeObject{
+			key:      testKey(mustTime(t, written), node),
+			modified: mustTime(t, delivered),
+			size:     100,
+		}
+	}
+	keysOf := func(objs ...fakeObject) []string {
+		var out []string
+		for _, o := range objs {
+			out = append(out, o.key)
+		}
+		return out
+	}
+	window := listOptions{window: windowSize}
+
+	t.Run("window", func(t *testing.T) {
+		early := obj("2024-01-02T10:05:00Z", "2024-01-02T10:09:59Z")
+		first := obj("2024-01-02T10:05:00Z", "2024-01-02T10:10:00Z")
+		last := obj("2024-01-02T10:10:00Z", "2024-01-02T10:14:59Z")
+		late := obj("2024-01-02T10:10:00Z", "2024-01-02T10:15:00Z")
+		client := fakeS3(t, []fakeObject{early, first, last, late})
+		// LastModified has a one second precision, so the fractional part
+		// of the reference time must not drop a file delivered at 10:10:00
+		ref := mustTime(t, "2024-01-02T10:10:00.75Z")
+		prefixes := windowPrefixes(ref, window.windowEnd(ref), "", "123456789012", "us-west-2")
+		got, err := candidateKeys(context.Background(), client, "bucket", nil, prefixes, ref, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := keysOf(first, last); !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		lag := window
+		lag.deliveryLag = time.Second
+		got, err = candidateKeys(context.Background(), client, "bucket", nil, prefixes, ref, lag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := keysOf(first, last, late); !slices.Equal(got, want) {
+			t.Errorf("with delivery lag: got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("hour", func(t *testing.T) {
+		before := obj("2024-01-02T10:55:00Z", "2024-01-02T10:57:59Z")
+		inside := []fakeObject{
+			obj("2024-01-02T10:55:00Z", "2024-01-02T10:58:00Z"),
+			obj("2024-01-02T10:55:00Z", "2024-01-02T10:59:59Z"),
+			obj("2024-01-02T10:55:00Z", "2024-01-02T11:00:00Z"),
+			obj("2024-01-02T11:00:00Z", "2024-01-02T11:02:59Z"),
+		}
+		after := obj("2024-01-02T11:00:00Z", "2024-01-02T11:03:00Z")
+		client := fakeS3(t, append([]fakeObject{before, after}, inside...))
+		ref := mustTime(t, "2024-01-02T10:58:00Z")
+		prefixes := windowPrefixes(ref, window.windowEnd(ref), "", "123456789012", "us-west-2")
+		if len(prefixes) != 1 {
+			t.Fatalf("window within a single day spans prefixes %q", prefixes)
+		}
+		got, err := candidateKeys(context.Background(), client, "bucket", nil, prefixes, ref, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := keysOf(inside...); !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("day", func(t *testing.T) {
+		// files written before midnight are delivered after it, and are
+		// found under the previous day's prefix
+		before := obj("2024-01-02T23:50:00Z", "2024-01-02T23:57:59Z")
+		yesterday := obj("2024-01-02T23:55:00Z", "2024-01-03T00:00:01Z")
+		today := obj("2024-01-03T00:00:00Z", "2024-01-03T00:02:59Z")
+		after := obj("2024-01-03T00:00:00Z", "2024-01-03T00:03:00Z")
+		client := fakeS3(t, []fakeObject{before, yesterday, today, after})
+		ref := mustTime(t, "2024-01-02T23:58:00Z")
+		prefixes := windowPrefixes(ref, window.windowEnd(ref), "", "123456789012", "us-west-2")
+		wantPrefixes := []string{
+			"AWSLogs/123456789012/elasticloadbalancing/us-west-2/2024/01/02",
+			"AWSLogs/123456789012/elasticloadbalancing/us-west-2/2024/01/03",
+		}
+		if !slices.Equal(prefixes, wantPrefixes) {
+			t.Fatalf("got prefixes %q, want %q", prefixes, wantPrefixes)
+		}
+		got, err := candidateKeys(context.Background(), client, "bucket", nil, prefixes, ref, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := keysOf(yesterday, today); !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		// a window ending exactly at midnight doesn't need the next day
+		ref = mustTime(t, "2024-01-02T23:55:00Z")
+		if prefixes := windowPrefixes(ref, window.windowEnd(ref), "", "123456789012", "us-west-2"); len(prefixes) != 1 {
+			t.Errorf("window ending at midnight spans prefixes %q", prefixes)
+		}
+	})
+
+	t.Run("key time", func(t *testing.T) {
+		// with -key-time, files are selected by the time in their names,
+		// at a minute precision, regardless of when they were delivered
+		before := obj("2024-01-02T23:54:00Z", "2024-01-02T23:56:00Z")
+		first := obj("2024-01-02T23:55:00Z", "2024-01-03T00:01:00Z")
+		last := obj("2024-01-02T23:59:00Z", "2024-01-03T00:04:00Z")
+		after := obj("2024-01-03T00:00:00Z", "2024-01-03T00:02:00Z")
+		client := fakeS3(t, []fakeObject{before, first, last, after})
+		opts := window
+		opts.keyTime = true
+		ref := mustTime(t, "2024-01-02T23:55:00Z")
+		prefixes := windowPrefixes(ref, opts.windowEnd(ref), "", "123456789012", "us-west-2")
+		got, err := candidateKeys(context.Background(), client, "bucket", nil, prefixes, ref, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := keysOf(first, last); !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestAccept(t *testing.T) {
+	prefix := "AWSLogs/123456789012/elasticloadbalancing/us-west-2/2024/01/02"
+	key := testKey(mustTime(t, "2024-01-02T10:05:00Z"), 1)
+	for _, tc := range []struct {
+		name string
+		opts listOptions
+		key  string
+		size int64
+		want bool
+	}{
+		{"log file", listOptions{}, key, 100, true},
+		{"other suffix", listOptions{}, strings.TrimSuffix(key, ".gz"), 100, false},
+		{"too small", listOptions{minSize: 101}, key, 100, false},
+		{"too large", listOptions{maxSize: 99}, key, 100, false},
+		{"after key", listOptions{afterKey: key}, key, 100, false},
+		{"before key", listOptions{afterKey: prefix}, key, 100, true},
+		{"node", listOptions{keySubstr: "_10.0.0.1_"}, key, 100, true},
+		{"other node", listOptions{keySubstr: "_10.0.0.2_"}, key, 100, false},
+		// the prefix itself has no node address
+		{"prefix", listOptions{keySubstr: "2024/01"}, key, 100, false},
+	} {
+		if got := tc.opts.accept(tc.key, prefix, tc.size); got != tc.want {
+			t.Errorf("%s: got %t, want %t", tc.name, got, tc.want)
+		}
+	}
+	// files compressed differently are only accepted if the build can
+	// read them
+	for _, suffix := range []string{".lz4", ".snappy"} {
+		got := listOptions{}.accept(strings.TrimSuffix(key, ".gz")+suffix, prefix, 100)
+		if want := decompressorFor(suffix) != nil; got != want {
+			t.Errorf("%s: got %t, want %t", suffix, got, want)
+		}
+	}
+}
+
+func TestListLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args runArgs
+		want int
+	}{
+		{"default", runArgs{MaxSamples: 10}, 10},
+		{"dry run", runArgs{MaxSamples: 10, DryRun: true}, 0},
+		// failed files are replaced by further candidates
+		{"keep going", runArgs{MaxSamples: 10, KeepGoing: true}, 0},
+	} {
+		if got := listLimit(&tc.args); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCandidateKeysStats(t *testing.T) {
+	ref := mustTime(t, "2024-01-02T10:05:00Z")
+	var objs []fakeObject
+	for i := range 3 {
+		objs = append(objs, fakeObject{key: testKey(ref, i), modified: ref.Add(time.Minute), size: int64(100 + i)})
+	}
+	objs[2].size = 1 // too small, not a candidate
+	client := fakeS3(t, objs)
+	opts := listOptions{window: windowSize, minSize: 10, stats: new(objectStats)}
+	prefixes := windowPrefixes(ref, opts.windowEnd(ref), "", "123456789012", "us-west-2")
+	if _, err := candidateKeys(context.Background(), client, "bucket", nil, prefixes, ref, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range objs[:2] {
+		st, ok := opts.stats.get(o.key)
+		if want := (fileStat{size: o.size, etag: fmt.Sprintf(`"%x"`, o.size)}); !ok || st != want {
+			t.Errorf("%s: got %+v, %t, want %+v", o.key, st, ok, want)
+		}
+	}
+	if _, ok := opts.stats.get(objs[2].key); ok {
+		t.Errorf("%s: not accepted, but recorded", objs[2].key)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// localFiles returns paths of files inside dir with names matching pattern,
+// in lexical order. maxDepth limits how many levels of subdirectories are
+// visited, negative value means no limit. If followSymlinks is false, symbolic
+// links are ignored, otherwise both links to files and directories are
+// followed, visiting each directory at most once.
+func localFiles(dir, pattern string, maxDepth int, followSymlinks bool) ([]string, error) {
+	var out []string
+	seen := make(map[string]struct{}) // resolved paths of visited directories
+	var walk func(root string, rootDepth int) error
+	walk = func(root string, rootDepth int) error {
+		real, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[real]; ok {
+			return nil
+		}
+		seen[real] = struct{}{}
+		if real != root {
+			// WalkDir does not descend into a root that is a symbolic link
+			root = real
+		}
+		return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			depth := rootDepth + pathDepth(root, p)
+			if d.IsDir() {
+				if p != root && maxDepth >= 0 && depth+1 > maxDepth {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				if !followSymlinks {
+					return nil
+				}
+				fi, err := os.Stat(p)
+				if err != nil {
+					return err
+				}
+				if fi.IsDir() {
+					if maxDepth >= 0 && depth+1 > maxDepth {
+						return nil
+					}
+					return walk(p, depth+1)
+				}
+			} else if !d.Type().IsRegular() {
+				return nil
+			}
+			if ok, _ := filepath.Match(pattern, d.Name()); ok {
+				out = append(out, p)
+			}
+			return nil
+		})
+	}
+	if err := walk(dir, 0); err != nil {
+		return nil, err
+	}
+	// the same file may be reached over different symbolic links
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// pathDepth returns the number of directory levels between root and p; files
+// and directories directly inside root have depth 0.
+func pathDepth(root, p string) int {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator))
+}
+
+func statLocalFile(_ context.Context, name string) (fileStat, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileStat{}, err
+	}
+	return fileStat{size: fi.Size()}, nil
+}
+
+func openLocalFile(_ context.Context, name string) (io.ReadCloser, error) { return os.Open(name) }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"strings"
+	"sync"
+)
+
+// quotedFields are log fields that ALB always encloses in double quotes.
+var quotedFields = map[string]bool{
+	"request":                 true,
+	"user_agent":              true,
+	"trace_id":                true,
+	"domain_name":             true,
+	"chosen_cert_arn":         true,
+	"actions_executed":        true,
+	"redirect_url":            true,
+	"error_reason":            true,
+	"target_port_list":        true,
+	"target_status_code_list": true,
+	"classification":          true,
+	"classification_reason":   true,
+}
+
+// entryWriter writes log entries to a gzip-compressed file in the access log
+// format, see -log-out, so that other tools reading ALB logs can consume
+// them. It is safe for concurrent use.
+//
+// Entries are formatted as they are parsed, but only written once their rows
+// are committed to the database, so that the file has the same entries as
+// the database even if loading of some file fails midway.
+//
+// Fields are quoted the way ALB does it, which encoding/csv cannot
+// reproduce: it escapes double quotes by doubling them rather than with a
+// backslash, and only quotes fields when it has to. Fields that ALB doesn't
+// quote are still quoted if they are empty or have spaces or double quotes,
+// so that splitLine reads them back as is.
+type entryWriter struct {
+	mu     sync.Mutex
+	f      *os.File
+	zw     *gzip.Writer
+	w      *bufio.Writer
+	quoted []bool // whether ALB quotes fields, in the order of loader.cols
+}
+
+// createEntryWriter creates the file name, compressing it with the gzip
+// level. Fields of written entries are in the order of cols.
+func createEntryWriter(name string, level int, cols []string) (*entryWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	zw, err := gzip.NewWriterLevel(f, level)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	quoted := make([]bool, len(cols))
+	for i, name := range cols {
+		quoted[i] = quotedFields[name]
+	}
+	return &entryWriter{f: f, zw: zw, w: bufio.NewWriter(zw), quoted: quoted}, nil
+}
+
+// appendEntry appends a single entry having fields in the order of cols
+// passed to createEntryWriter, followed by any unknown trailing fields, to b.
+func (e *entryWriter) appendEntry(b []byte, fields []string) []byte {
+	for i, s := range fields {
+		if i != 0 {
+			b = append(b, ' ')
+		}
+		if (i >= len(e.quoted) || !e.quoted[i]) && s != "" && !strings.ContainsAny(s, ` "`) {
+			b = append(b, s...)
+			continue
+		}
+		b = append(b, '"')
+		for j := 0; j < len(s); j++ {
+			if s[j] == '"' || s[j] == '\\' {
+				b = append(b, '\\')
+			}
+			b = append(b, s[j])
+		}
+		b = append(b, '"')
+	}
+	return append(b, '\n')
+}
+
+// write writes entries formatted by appendEntry.
+func (e *entryWriter) write(entries []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(entries)
+	return err
+}
+
+// close flushes written entries and closes the file.
+func (e *entryWriter) close() error {
+	defer e.f.Close()
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	if err := e.zw.Close(); err != nil {
+		return err
+	}
+	return e.f.Close()
+}
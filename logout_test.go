@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/artyom/status"
+)
+
+func TestEntryWriterRoundTrip(t *testing.T) {
+	cols := logFields(&runArgs{})
+	name := filepath.Join(t.TempDir(), "out.log.gz")
+	ew, err := createEntryWriter(name, gzip.DefaultCompression, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures := []string{lineHTTP, lineQuotedAgent, lineIPv6, lineGRPC, lineWAF, lineH2}
+	var want [][]string
+	var entries []byte
+	for _, line := range fixtures {
+		fields, err := splitLine(nil, line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, fields)
+		entries = ew.appendEntry(entries, fields)
+	}
+	if err := ew.write(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.close(); err != nil {
+		t.Fatal(err)
+	}
+	got := readEntries(t, name)
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !slices.Equal(got[i], want[i]) {
+			t.Errorf("entry %d:\ngot  %q\nwant %q", i+1, got[i], want[i])
+		}
+	}
+}
+
+// readEntries returns fields of entries in the gzip-compressed log file.
+func readEntries(t *testing.T, name string) [][]string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out [][]string
+	sc := bufio.NewScanner(zr)
+	sc.Buffer(nil, maxLineSize)
+	for sc.Scan() {
+		fields, err := splitLine(nil, sc.Text())
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, fields)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// TestOutputCommitted checks that -log-out and -jsonl-out have the same rows
+// as the database when a file fails to load midway.
+func TestOutputCommitted(t *testing.T) {
+	for _, parallel := range []int{1, 3} {
+		ctx := context.Background()
+		dir := t.TempDir()
+		keys, _ := writeLogFiles(t, dir, 6)
+		// a file failing to load after some of its rows are committed
+		broken := filepath.Join(dir, "broken.log.gz")
+		lines := make([]string, 25, 26)
+		for i := range lines {
+			lines[i] = lineH2
+		}
+		b, err := io.ReadAll(gzipLines(t, append(lines, `h2 "unterminated`)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(broken, b, 0666); err != nil {
+			t.Fatal(err)
+		}
+		keys = slices.Insert(keys, 2, broken)
+		args := &runArgs{
+			Parallel:      parallel,
+			DecodeWorkers: 2,
+			MaxSamples:    len(keys),
+			SampleRate:    1,
+			KeepGoing:     true,
+			SourceColumn:  true,
+			CommitEvery:   10,
+		}
+		src := &logSource{name: "test", open: openLocalFile, stat: statLocalFile}
+		ld, err := openDatabase(ctx, args, filepath.Join(dir, "test.db"), src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "out.log.gz")
+		if ld.logOut, err = createEntryWriter(out, gzip.BestSpeed, ld.cols); err != nil {
+			t.Fatal(err)
+		}
+		var jsonl bytes.Buffer
+		ld.rowOut = newRowEncoder(&jsonl, ld.columns, ld.numeric)
+		res, err := loadFiles(ctx, args, ld, src, keys, new(status.Line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ld.logOut.close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ld.rowOut.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(res.failed, []string{broken}) {
+			t.Errorf("-parallel %d: got failed files %q, want %q", parallel, res.failed, broken)
+		}
+		var rows, brokenRows int
+		if err := ld.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM logs`).Scan(&rows); err != nil {
+			t.Fatal(err)
+		}
+		if err := ld.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM logs WHERE source_key=?`, broken).Scan(&brokenRows); err != nil {
+			t.Fatal(err)
+		}
+		ld.db.Close()
+		entries := readEntries(t, out)
+		if len(entries) != rows {
+			t.Errorf("-parallel %d: -log-out has %d entries, the database has %d rows", parallel, len(entries), rows)
+		}
+		// only the rows of the broken file committed before it failed
+		if brokenRows != 20 {
+			t.Errorf("-parallel %d: the database has %d rows of the broken file, want 20", parallel, brokenRows)
+		}
+		h2, err := splitLine(nil, lineH2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		for _, e := range entries {
+			if slices.Equal(e[:len(h2)], h2) {
+				n++
+			}
+		}
+		if n != brokenRows {
+			t.Errorf("-parallel %d: -log-out has %d entries of the broken file, the database has %d", parallel, n, brokenRows)
+		}
+		var jsonRows, jsonBroken int
+		for dec := json.NewDecoder(&jsonl); dec.More(); jsonRows++ {
+			var row struct {
+				SourceKey string `json:"source_key"`
+			}
+			if err := dec.Decode(&row); err != nil {
+				t.Fatal(err)
+			}
+			if row.SourceKey == broken {
+				jsonBroken++
+			}
+		}
+		if jsonRows != rows || jsonBroken != brokenRows {
+			t.Errorf("-parallel %d: -jsonl-out has %d rows, %d of the broken file, the database has %d and %d",
+				parallel, jsonRows, jsonBroken, rows, brokenRows)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build lz4
+
+package main
+
+import (
+	"io"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+func init() {
+	// the frame format, as written by the lz4 tool
+	decompressors[".lz4"] = func(r io.Reader) (io.Reader, error) { return lz4.NewReader(r), nil }
+}
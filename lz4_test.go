@@ -0,0 +1,132 @@
+//go:build lz4
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// lz4Sample returns the text files in testdata/lz4 are compressed from, with
+//
+//	lz4 -q sample.txt default.lz4
+//	lz4 -q -B4 -BD -BX --content-size sample.txt linked.lz4
+//	lz4 -q -B5 --no-frame-crc sample.txt nocrc.lz4
+//
+// It's long enough to take several 64 KiB blocks.
+func lz4Sample() []byte {
+	fixtures := []string{lineHTTP, lineQuotedAgent, lineIPv6, lineGRPC, lineWAF}
+	var b bytes.Buffer
+	for i := range 1000 {
+		fmt.Fprintf(&b, "%d %s\n", i*i, fixtures[i%len(fixtures)])
+	}
+	return b.Bytes()
+}
+
+func TestLZ4Sample(t *testing.T) {
+	if os.Getenv("LZ4_WRITE_SAMPLE") == "" {
+		t.Skip("set LZ4_WRITE_SAMPLE to write testdata/lz4/sample.txt")
+	}
+	if err := os.WriteFile(filepath.Join("testdata", "lz4", "sample.txt"), lz4Sample(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLZ4(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "lz4", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func decompressLZ4(b []byte) ([]byte, error) {
+	r, err := decompressorFor("x.log.lz4")(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestLZ4Frames(t *testing.T) {
+	want := lz4Sample()
+	// a skippable frame, as some tools write to carry metadata
+	skippable := binary.LittleEndian.AppendUint32(nil, 0x184D2A57)
+	skippable = binary.LittleEndian.AppendUint32(skippable, 5)
+	skippable = append(skippable, "extra"...)
+	for _, tc := range []struct {
+		name  string
+		input []byte
+		want  []byte
+	}{
+		// independent blocks, content checksum
+		{"default", readLZ4(t, "default.lz4"), want},
+		// blocks referring to previous ones, block checksums, content
+		// size in the header
+		{"linked", readLZ4(t, "linked.lz4"), want},
+		{"no checksum", readLZ4(t, "nocrc.lz4"), want},
+		{"concatenated", bytes.Join([][]byte{readLZ4(t, "linked.lz4"), readLZ4(t, "default.lz4")}, nil),
+			bytes.Repeat(want, 2)},
+		{"skippable", bytes.Join([][]byte{skippable, readLZ4(t, "nocrc.lz4"), skippable, readLZ4(t, "default.lz4")}, nil),
+			bytes.Repeat(want, 2)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decompressLZ4(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("got %d bytes of decompressed data, want %d", len(got), len(tc.want))
+			}
+		})
+	}
+}
+
+func TestLZ4Corrupted(t *testing.T) {
+	corrupt := func(name string, i int) []byte {
+		b := bytes.Clone(readLZ4(t, name))
+		if i < 0 {
+			i += len(b)
+		}
+		b[i] ^= 0x20
+		return b
+	}
+	for _, tc := range []struct {
+		name  string
+		input []byte
+	}{
+		{"header checksum", corrupt("default.lz4", 6)},
+		{"block checksum", corrupt("linked.lz4", 20)},
+		// the first literals of the first block: "0 http..."
+		{"content checksum", corrupt("default.lz4", 16)},
+		{"content checksum value", corrupt("default.lz4", -1)},
+		{"truncated", readLZ4(t, "default.lz4")[:1000]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := decompressLZ4(tc.input); err == nil {
+				t.Error("corrupted data decompressed without an error")
+			}
+		})
+	}
+	if _, err := decompressLZ4([]byte("\x1f\x8b\x08\x00 not lz4")); err == nil {
+		t.Error("gzip data decompressed without an error")
+	}
+}
+
+func TestCountRowsLZ4(t *testing.T) {
+	name := filepath.Join("testdata", "lz4", "default.lz4")
+	_, rows, err := countRows(context.Background(), openLocalFile, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Count(lz4Sample(), []byte("\n")); rows != want {
+		t.Errorf("got %d rows, want %d", rows, want)
+	}
+}
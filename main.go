@@ -3,38 +3,57 @@
 package main
 
 import (
+	"bufio"
 	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"database/sql"
 	_ "embed"
-	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/artyom/status"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	alb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
 	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"golang.org/x/term"
 	_ "modernc.org/sqlite"
 )
 
 const timeLayout = "2006-01-02T15:04"
 
+// windowSize is the default duration of the time window log files are taken
+// from, measured by their delivery time.
+const windowSize = 5 * time.Minute
+
 func main() {
 	log.SetFlags(0)
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
@@ -43,16 +62,208 @@ func main() {
 	flag.IntVar(&args.MaxSamples, "n", args.MaxSamples, "load at most this `number` of candidate log files")
 	flag.StringVar(&args.Database, "db", "", "`path` to the database file; "+
 		"if empty, use a file in a temporary directory.\n"+
-		"The same database file may be reused between program runs.")
+		"The same database file may be reused between program runs:\n"+
+		"entries are appended, skipping log files loaded before, unless -truncate is set.\n"+
+		"Use :memory: to only print the summary without keeping any data.")
 	flag.StringVar(&args.TimeString, "time", "", "take log sample around this `time`, format is either "+
-		"hh:mm\nfor today, or yyyy-mm-ddThh:mm for an arbitrary date;\n"+
+		"hh:mm\nfor today, yyyy-mm-ddThh:mm for an arbitrary date,\n"+
+		"Unix time in seconds or milliseconds (13 digits), or one of\n"+
+		"the keywords: now, yesterday (current time a day ago),\n"+
+		"or a start..end range of these, like 14:00..15:30;\n"+
 		"if empty, take reference time as few minutes to the past")
 	flag.BoolVar(&args.UTC, "utc", false, "treat time as UTC instead of local time zone")
+	flag.StringVar(&args.Timezone, "timezone", "", "treat time as in this IANA time `zone` (e.g. America/New_York)\n"+
+		"instead of local time zone; overrides -utc")
 	flag.StringVar(&args.Profile, "p", "default", "the Shared Configuration `profile` to use\n"+
 		"See https://go.aws/3KQT4Q6 for more information")
+	flag.StringVar(&args.AccessKey, "access-key", "", "AWS access key `id` to use instead of the default credential chain;\n"+
+		"requires -secret-key. Prefer profiles or environment variables:\n"+
+		"flag values may leak through the process list and shell history")
+	flag.StringVar(&args.SecretKey, "secret-key", "", "AWS secret access `key`, used with -access-key")
+	flag.StringVar(&args.SessionToken, "session-token", "", "optional session `token` for temporary credentials,\n"+
+		"used with -access-key and -secret-key")
+	flag.StringVar(&args.SSECustomerKey, "sse-customer-key", "", "base64-encoded 256-bit `key` to read log files encrypted\n"+
+		"with customer-provided keys (SSE-C)")
+	flag.StringVar(&args.Dir, "dir", "", "load log files from this local directory `path` instead of S3;\n"+
+		"-time is ignored, files are loaded in lexical order")
+	flag.StringVar(&args.HTTPProxy, "http-proxy", "", "send AWS API requests through the proxy at this `URL`;\n"+
+		"by default, HTTPS_PROXY and related environment variables are used")
+	flag.DurationVar(&args.HTTPTimeout, "http-timeout", 0, "fail AWS API requests if connecting or waiting for a response takes\n"+
+		"longer than this `duration` (e.g. 10s); downloads of log files are not limited")
+	flag.IntVar(&args.Retries, "retries", 2, "retry failed AWS API requests, both to S3 and to the load balancer API,\n"+
+		"up to this `number` of times, with exponential backoff and jitter; throttled\n"+
+		"requests are retried too, which matters when many runs share an account")
+	flag.BoolVar(&args.InsecureSkipVerify, "insecure-skip-verify", false, "don't verify TLS certificates of AWS endpoints, which is insecure;\n"+
+		"only meant for networks with TLS-inspecting proxies using internal certificates")
+	flag.StringVar(&args.BucketOwner, "bucket-owner", "", "AWS account `id` expected to own the logs bucket; S3 rejects requests\n"+
+		"if the bucket is owned by any other account")
+	flag.StringVar(&args.Glob, "glob", "*.log.gz", "with -dir, only load files with names matching this `pattern`;\n"+
+		"builds with snappy and lz4 tags also read *.snappy and *.lz4 files, select them with e.g. -glob '*.lz4'")
+	flag.IntVar(&args.MaxDepth, "max-depth", -1, "with -dir, descend at most this `number` of directory levels;\n"+
+		"0 only loads files directly inside the directory, negative means no limit")
+	flag.BoolVar(&args.FollowSymlinks, "follow-symlinks", false, "with -dir, follow symbolic links to files and directories")
+	flag.Float64Var(&args.SampleRate, "sample-rate", 1, "keep each log entry with this `probability`, in the (0,1] range;\n"+
+		"the rate is recorded in the run_meta table to scale aggregates")
+	flag.Uint64Var(&args.Seed, "seed", 0, "random `seed` used with -sample-rate; the same seed selects the same\n"+
+		"entries from the same log files")
+	flag.BoolVar(&args.Fast, "fast", false, "don't wait for -db writes to reach the disk: loading is faster,\n"+
+		"but the database may get corrupted on crash or power loss;\n"+
+		"this is always the case for databases in a temporary directory")
+	flag.IntVar(&args.Head, "head", 0, "print this `number` of first entries parsed from candidate log files, after\n"+
+		"filters, as column: value lines, and exit without loading anything;\n"+
+		"this checks field alignment and filters before a long load")
+	flag.BoolVar(&args.PrintKeys, "print-keys", false, "print s3://bucket/key URLs of log files that would be loaded\n"+
+		"(local paths with -dir), one per line, and exit")
+	flag.BoolVar(&args.PrintConfig, "print-config", false, "print effective settings as JSON, after applying saved flags and\n"+
+		"discovering the logs location: profile, region, bucket, listed prefixes,\n"+
+		"time window, number of files, and database path, then exit without listing")
+	flag.BoolVar(&args.MetadataOnly, "metadata-only", false, "print where the load balancer delivers logs: account, region, bucket,\n"+
+		"and prefix, as discovered over AWS API or cached, in -format, and exit")
+	flag.BoolVar(&args.NoShell, "no-shell", false, "never start sqlite3; print the summary to stderr and the absolute\n"+
+		"database path to stdout, so that scripts can capture it")
+	flag.BoolVar(&args.Healthcheck, "healthcheck", false, "verify that configuration and permissions allow loading logs of the load balancer:\n"+
+		"make every kind of API call a regular run makes, report each result, and exit")
+	flag.BoolVar(&args.StatsOnly, "stats-only", false, "load logs into an in-memory database and print aggregates as JSON:\n"+
+		"status code classes, latency percentiles, and the most active clients")
+	flag.BoolVar(&args.DryRun, "dry-run", false, "only list candidate log files and print their total size and estimated\n"+
+		"number of rows, extrapolated from the first file, without loading anything")
+	flag.IntVar(&args.Parallel, "parallel", 1, "download and parse this `number` of log files concurrently;\n"+
+		"rows are still written to the database by a single writer")
+	flag.IntVar(&args.DecodeWorkers, "decode-workers", runtime.GOMAXPROCS(0), "with -parallel, decompress and parse at most this `number` of\n"+
+		"downloaded log files at the same time")
+	flag.IntVar(&args.CommitEvery, "commit-every", 0, "commit loaded rows every this `number` of rows, bounding the WAL size\n"+
+		"for very large files; a file interrupted midway resumes where it stopped;\n"+
+		"0 loads each file in a single transaction")
+	flag.StringVar(&args.Preset, "preset", "", "print results of the named query instead of starting sqlite3; `name` is one of\n"+
+		strings.Join(presetNames(), ", "))
+	flag.StringVar(&args.Format, "format", "table", "output `format` of -preset results and -metadata-only: table, csv, or json")
+	flag.IntVar(&args.FloatPrecision, "float-precision", -1, "write fractional numbers of -preset results and -sql-dump in fixed-point\n"+
+		"notation with this `number` of decimal digits; by default, the shortest exact\n"+
+		"form is used, which has an exponent for very small or large numbers")
+	flag.Int64Var(&args.MinSize, "min-size", 1, "skip S3 log files smaller than this number of `bytes`;\n"+
+		"the default only skips empty placeholder objects")
+	flag.Int64Var(&args.MaxSize, "max-file-size", 0, "skip S3 log files larger than this number of `bytes`, logging a warning,\n"+
+		"so that a single huge object doesn't take up the whole run; 0 means no limit")
+	flag.StringVar(&args.KeySubstring, "s3-prefix-suffix", "", "only load S3 log files with keys containing this `text` after the date prefix,\n"+
+		"e.g. the IP address of a single load balancer node")
+	flag.BoolVar(&args.Latest, "latest", false, "load the most recently delivered log files, found in the newest date\n"+
+		"prefix of the bucket however old it is, instead of those around -time")
+	flag.StringVar(&args.SQS, "sqs", "", "keep loading log files as S3 notifies the SQS queue at this `URL` of their\n"+
+		"creation, until interrupted; messages are deleted once their files are loaded,\n"+
+		"and right away if they are not S3 event notifications, so configure a redrive\n"+
+		"policy to keep those. Configure the logs bucket to send ObjectCreated events\n"+
+		"to the queue first")
+	flag.StringVar(&args.AfterKey, "after-key", "", "only load S3 log files with keys sorting strictly after this `key`, within\n"+
+		"the time window as usual; keys at or before it are skipped whether they were\n"+
+		"loaded or not. Keys embed the minute files were written, so this roughly\n"+
+		"continues after a previous run, but doesn't resume it exactly: that run may\n"+
+		"have sampled files, completed them out of order with -parallel, or missed\n"+
+		"files of other nodes from the same minute that sort before its last key")
+	flag.DurationVar(&args.DeliveryLag, "delivery-lag", 0, "extend the time window by this `duration` (e.g. 5m): files are\n"+
+		"selected by delivery time, which may lag behind request times in them")
+	flag.BoolVar(&args.KeyTimeFilter, "key-time-filter", false, "select S3 log files by the time in their names, when the load balancer\n"+
+		"wrote them, rather than by the delivery time S3 reports; this is more\n"+
+		"precise for narrow windows, as delivery may lag behind by minutes")
+	flag.StringVar(&args.Bucket, "bucket", "", "read log files from this S3 bucket `name` instead of the one configured\n"+
+		"for the load balancer, which is then not looked up over AWS API")
+	flag.StringVar(&args.Account, "account", "", "AWS account `id` in the standard log files prefix, by default the\n"+
+		"load balancer account; required with -bucket, unless it is known otherwise")
+	flag.StringVar(&args.Prefix, "prefix", "", "list log files under this key `template` instead of the standard\n"+
+		"AWSLogs/account/elasticloadbalancing/region/yyyy/mm/dd/ prefix; %Y, %m, %d, and %H\n"+
+		"stand for UTC year, month, day, and hour, {account} and {region} for the load balancer ones")
+	flag.StringVar(&args.KeysFrom, "keys-from", "", "load log files with keys listed in this `file`, one per line,\n"+
+		"instead of listing the bucket; with -dir, the file lists paths")
+	flag.StringVar(&args.Inventory, "inventory", "", "find candidate log files in the S3 Inventory report described by the manifest\n"+
+		"at this `s3://bucket/path/manifest.json` URL, instead of listing the logs bucket;\n"+
+		"only CSV reports are supported, and they only cover files delivered before the report")
+	flag.StringVar(&args.Tag, "tag", "", "only load S3 log files having this object tag, in `key=value` form;\n"+
+		"tags of candidate files are checked after listing them")
+	flag.IntVar(&args.PerPrefix, "limit-files-per-prefix", 0, "take at most this `number` of S3 log files from each day's prefix,\n"+
+		"spreading the sample over all days of the time window; 0 means no limit")
+	flag.BoolVar(&args.NormalizeURL, "normalize-url", false, "add the request_path_normalized column holding request paths with\n"+
+		"numeric, UUID, and long hexadecimal segments replaced by {id}, {uuid}, and {hex}")
+	flag.Func("path-rule", "replace request path segments matching `regexp` with a placeholder in the\n"+
+		"request_path_normalized column, implies -normalize-url; use regexp=placeholder to\n"+
+		"set the placeholder, {param} by default. May be repeated, rules are tried in order\n"+
+		"before the built-in ones", func(s string) error {
+		args.PathRules = append(args.PathRules, s)
+		return nil
+	})
+	flag.StringVar(&args.ThreatList, "threat-list", "", "add the client_flagged column telling whether the client address\n"+
+		"is in this `file` listing IP addresses and CIDR ranges, one per line")
+	flag.BoolVar(&args.SourceColumn, "add-source-column", false, "add the source_key column holding the key of the log file each entry comes from;\n"+
+		"must be set when the database is created")
+	flag.StringVar(&args.FieldsFile, "fields-file", "", "read names of log entry fields from this `file`, one per line,\n"+
+		"instead of using the built-in list")
+	flag.BoolVar(&args.RawColumns, "raw-columns", false, "name database columns exactly as AWS documents log fields,\n"+
+		"instead of using shorter aliases for some of them (e.g. elb_status for elb_status_code)")
+	flag.BoolVar(&args.TimeMS, "time-ms", false, "store processing times as INTEGER milliseconds instead of REAL seconds;\n"+
+		"missing values (logged as -1) are stored as NULL")
+	flag.DurationVar(&args.Rollup, "rollup", 0, "also fill the rollups table with request counts, status code classes,\n"+
+		"and latency aggregates over periods of this `duration` (e.g. 1m)")
+	flag.BoolVar(&args.Finalize, "finalize", false, "once loading is done, switch the database out of WAL mode, so it's\n"+
+		"a single self-contained file without -wal and -shm companions")
+	flag.BoolVar(&args.Truncate, "truncate", false, "remove all entries from a reused database before loading logs,\n"+
+		"instead of appending to them")
+	flag.BoolVar(&args.KeepSchema, "include-columns-from-db", false, "when reusing a database, only fill columns its logs table already has,\n"+
+		"instead of adding columns for fields and derived values it lacks; for databases\n"+
+		"shared with other versions of the program or other tools expecting their layout")
+	flag.BoolVar(&args.Inspect, "inspect", false, "open an existing -db database without loading any logs:\n"+
+		"print its summary and start sqlite3 in read-only mode")
+	flag.BoolVar(&args.NoHealthChecks, "exclude-health-checks", false, "skip requests made by target health checks\n"+
+		"(user agent ELB-HealthChecker)")
+	flag.IntVar(&args.MinStatus, "min-status", 0, "only load requests with ELB status code of at least this `code` (e.g. 400)")
+	flag.IntVar(&args.MaxStatus, "max-status", 0, "only load requests with ELB status code of at most this `code`")
+	flag.StringVar(&args.Target, "target", "", "only load requests forwarded to targets with this IP `address`\n"+
+		"or within this CIDR range (e.g. 10.0.1.0/24)")
+	flag.StringVar(&args.SSLProtocol, "ssl-protocol", "", "only load requests made over these comma-separated TLS `protocols`\n"+
+		"(e.g. TLSv1,TLSv1.1), as logged in ssl_protocol; unencrypted requests are skipped")
+	flag.StringVar(&args.SummaryOut, "summary-out", "", "also write the summary of loaded entries, with latency percentiles and\n"+
+		"top clients, to `file`: as JSON if its name ends with .json, as text otherwise")
+	flag.StringVar(&args.Manifest, "manifest", "", "write a JSON manifest of the log files processed by this run to `file`:\n"+
+		"their keys, sizes, ETags, and numbers of added rows")
+	flag.StringVar(&args.JSONLOut, "jsonl-out", "", "also write every loaded row as a JSON object per line to `file`,\n"+
+		"or to standard output if it is -, in which case sqlite3 is not started;\n"+
+		"combine with -db :memory: to only stream rows")
+	flag.StringVar(&args.SQLDump, "sql-dump", "", "also write the loaded table as CREATE TABLE and INSERT statements with\n"+
+		"standard SQL types to `file`, for importing into another database, or to\n"+
+		"standard output if it is -, in which case sqlite3 is not started")
+	flag.StringVar(&args.LogOut, "log-out", "", "also write every loaded entry, as it appears in log files, to a gzip-compressed\n"+
+		"`file` in the access log format, e.g. to share a filtered sample with other tools")
+	flag.IntVar(&args.CompressLevel, "compress-level", gzip.DefaultCompression, "gzip compression `level` of the -log-out file, from 1 (fastest) to 9 (best)")
+	flag.StringVar(&args.Compare, "compare", "", "also load logs around this `time` (same formats as -time) and\n"+
+		"print a side-by-side comparison of the two windows instead of starting sqlite3;\n"+
+		"each window is kept in its own database in a temporary directory")
+	flag.BoolVar(&args.KeepGoing, "keep-going", false, "skip log files that fail to load, loading other candidates in their place;\n"+
+		"failed files are listed at the end and the program exits with status 1")
+	flag.BoolVar(&args.Restore, "restore", false, "request restoration of log files in Glacier or archive storage classes;\n"+
+		"such files are otherwise skipped, re-run once restoration completes")
+	flag.BoolVar(&args.ProgressBar, "progress-bar", false, "show a progress bar with loaded files, downloaded bytes, and ETA;\n"+
+		"if standard error is not a terminal, log each loaded file instead")
+	flag.Func("sqlite-ext", "load the SQLite extension at `path` into the sqlite3 shell, may be repeated;\n"+
+		"extensions are not available to -preset queries, which don't use the shell", func(s string) error {
+		if strings.Contains(s, "'") {
+			return errors.New("extension path cannot contain single quotes")
+		}
+		args.Extensions = append(args.Extensions, s)
+		return nil
+	})
+	flag.StringVar(&args.Color, "color", "auto", "colorize summary output: `mode` is auto, always, or never;\n"+
+		"auto only uses color on a terminal and when NO_COLOR is not set")
+	flag.DurationVar(&args.MaxRuntime, "max-runtime", 0, "stop after this `duration`, keeping log files loaded so far;\n"+
+		"if the limit is hit, the program exits with status 3")
+	flag.StringVar(&args.OTelEndpoint, "otel-endpoint", "", "export OpenTelemetry traces of the run, with spans for discovery, listing, and\n"+
+		"loading of each log file, to the OTLP/HTTP collector at this base `URL`\n"+
+		"(e.g. http://localhost:4318); spans are sent as JSON once loading completes")
 
-	var cleanup bool
+	var benchmarkEntries int
+	flag.IntVar(&benchmarkEntries, "benchmark", 0, "measure local ingestion speed on this `number` of synthetic log entries and exit")
+	var cleanup, printSchemaVersion, forget bool
+	flag.BoolVar(&forget, "forget", false, "forget flags saved for the load balancer and exit; flags like -p,\n"+
+		"-timezone, or -parallel are saved per load balancer and used as defaults on later runs")
 	flag.BoolVar(&cleanup, "clean", false, "clean cache and temporary files and exit")
+	flag.BoolVar(&printSchemaVersion, "output-schema-version", false, "print the version of the database schema this program creates and exit;\n"+
+		"it is stored as the database user_version")
 	flag.Parse()
 
 	if cleanup {
@@ -60,195 +271,1577 @@ func main() {
 		_ = os.RemoveAll(cacheDir())
 		return
 	}
+	if printSchemaVersion {
+		fmt.Println(schemaVersion)
+		return
+	}
+	if benchmarkEntries > 0 {
+		if err := args.populate(); err != nil {
+			log.Fatal(err)
+		}
+		if err := benchmark(ctx, os.Stdout, &args, benchmarkEntries); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	if err := run(ctx, &args, flag.Arg(0)); err != nil {
+	if targets := flag.Args(); len(targets) != 0 && args.Dir == "" {
+		var err error
+		if args.saveFlags, err = applySavedFlags(strings.Join(targets, " "), forget); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if forget {
+		return
+	}
+
+	err := withTracing(ctx, &args, func(ctx context.Context) error {
+		return run(ctx, &args, flag.Args())
+	})
+	if err != nil {
 		if err == errUsage {
 			flag.Usage()
 			os.Exit(2)
 		}
-		log.Fatal(err)
+		log.Print(err)
+		os.Exit(exitCode(err))
 	}
 }
 
 type runArgs struct {
-	MaxSamples int
-	UTC        bool
-	TimeString string
-	Database   string
-	Profile    string
-	time       time.Time
+	MaxSamples  int
+	UTC         bool
+	Timezone    string
+	TimeString  string
+	Database    string
+	Profile     string
+	MaxRuntime  time.Duration
+	Color       string
+	ProgressBar bool
+	Extensions  []string
+	Inspect     bool
+	Truncate    bool
+	KeepSchema  bool
+	Finalize    bool
+	TimeMS      bool
+	Rollup      time.Duration
+	Fast        bool
+	RawColumns  bool
+	FieldsFile  string
+	Restore     bool
+	KeepGoing   bool
+	Compare     string
+	Manifest    string
+	SummaryOut  string
+	JSONLOut    string
+	SQLDump     string
+	LogOut      string
+
+	CommitEvery   int
+	CompressLevel int
+	Parallel      int
+	DecodeWorkers int
+	MinSize       int64
+	MaxSize       int64
+	KeySubstring  string
+	AfterKey      string
+	SQS           string
+	Latest        bool
+	Tag           string
+	Inventory     string
+	KeysFrom      string
+	Prefix        string
+	Bucket        string
+	Account       string
+	DeliveryLag   time.Duration
+	KeyTimeFilter bool
+	PerPrefix     int
+	SourceColumn  bool
+	NormalizeURL  bool
+	ThreatList    string
+	PathRules     []string
+
+	Preset         string
+	Format         string
+	FloatPrecision int
+	DryRun         bool
+	StatsOnly      bool
+	Healthcheck    bool
+	NoShell        bool
+	PrintKeys      bool
+	Head           int
+	PrintConfig    bool
+	MetadataOnly   bool
+
+	NoHealthChecks bool
+	Target         string
+	MinStatus      int
+	MaxStatus      int
+	SSLProtocol    string
+	SampleRate     float64
+	Seed           uint64
+
+	Dir            string
+	Glob           string
+	MaxDepth       int
+	FollowSymlinks bool
+
+	AccessKey      string
+	SecretKey      string
+	SSECustomerKey string
+	SessionToken   string
+	BucketOwner    string
+
+	HTTPProxy          string
+	HTTPTimeout        time.Duration
+	InsecureSkipVerify bool
+	Retries            int
+	OTelEndpoint       string
+
+	tag          *objectTag
+	proxy        *url.URL
+	sseKey       *sseCustomerKey
+	target       netip.Prefix
+	location     *time.Location // time zone -time and -compare are interpreted in
+	window       Window
+	compareTime  time.Time
+	regionColumn bool // whether to add the region column, set for several load balancers
+	pathRules    []pathRule
+	fields       []string // log fields read from -fields-file
+	threats      *prefixSet
+	saveFlags    func() error // saves persistent flags, see applySavedFlags
 }
 
 func (args *runArgs) populate() error {
 	if args.MaxSamples < 1 {
 		return errors.New("number of candidate log files must be a positive number")
 	}
-	if args.TimeString == "" {
-		args.time = time.Now().Add(-5 * time.Minute)
-	} else {
-		loc := time.Local
-		if args.UTC {
-			loc = time.UTC
+	if args.MaxRuntime < 0 {
+		return errors.New("maximum run time cannot be negative")
+	}
+	if args.Rollup < 0 || args.Rollup%time.Second != 0 {
+		return errors.New("-rollup must be a positive whole number of seconds")
+	}
+	for _, v := range []struct {
+		name  string
+		value int
+	}{{"-min-status", args.MinStatus}, {"-max-status", args.MaxStatus}} {
+		if v.value != 0 && (v.value < 100 || v.value > 599) {
+			return fmt.Errorf("%s must be an HTTP status code in the 100-599 range, got %d", v.name, v.value)
 		}
-		var t time.Time
-		var err error
-		if t, err = time.ParseInLocation("15:04", args.TimeString, loc); err == nil {
-			h, m, _ := t.Clock()
-			now := time.Now().In(loc)
-			t = time.Date(now.Year(), now.Month(), now.Day(), h, m, 0, 0, loc)
-		} else if t, err = time.ParseInLocation(timeLayout, args.TimeString, loc); err != nil {
+	}
+	if args.MinStatus != 0 && args.MaxStatus != 0 && args.MinStatus > args.MaxStatus {
+		return errors.New("-min-status cannot be greater than -max-status")
+	}
+	if args.OTelEndpoint != "" {
+		u, err := url.Parse(args.OTelEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("-otel-endpoint must be an http or https URL like http://localhost:4318, got %q", args.OTelEndpoint)
+		}
+		args.OTelEndpoint = strings.TrimSuffix(args.OTelEndpoint, "/")
+	}
+	if args.HTTPTimeout < 0 {
+		return errors.New("-http-timeout cannot be negative")
+	}
+	if args.Retries < 0 {
+		return errors.New("-retries cannot be negative")
+	}
+	if args.DeliveryLag < 0 {
+		return errors.New("-delivery-lag cannot be negative")
+	}
+	if args.CommitEvery < 0 {
+		return errors.New("-commit-every cannot be negative")
+	}
+	if args.PerPrefix < 0 {
+		return errors.New("-limit-files-per-prefix cannot be negative")
+	}
+	if args.Parallel < 1 {
+		return errors.New("-parallel must be a positive number")
+	}
+	if args.DecodeWorkers < 1 {
+		return errors.New("-decode-workers must be a positive number")
+	}
+	if args.MinSize < 0 {
+		return errors.New("-min-size cannot be negative")
+	}
+	if args.MaxSize < 0 {
+		return errors.New("-max-file-size cannot be negative")
+	}
+	if !(args.SampleRate > 0 && args.SampleRate <= 1) {
+		return errors.New("sample rate must be in the (0,1] range")
+	}
+	switch args.Color {
+	case "auto", "always", "never":
+	default:
+		return fmt.Errorf("unsupported -color value %q, must be one of: auto, always, never", args.Color)
+	}
+	switch args.Format {
+	case "table", "csv", "json":
+	default:
+		return fmt.Errorf("unsupported -format value %q, must be one of: table, csv, json", args.Format)
+	}
+	if _, ok := presets[args.Preset]; args.Preset != "" && !ok {
+		return fmt.Errorf("unknown preset %q, known presets are: %s", args.Preset, strings.Join(presetNames(), ", "))
+	}
+	if args.Truncate && args.Inspect {
+		return errors.New("-truncate cannot be used with -inspect")
+	}
+	if args.StatsOnly && (args.Database != "" || args.Preset != "" || args.Compare != "") {
+		return errors.New("-stats-only cannot be used with -db, -preset, or -compare")
+	}
+	if args.FloatPrecision < -1 {
+		return errors.New("-float-precision cannot be negative")
+	}
+	if args.Latest && (args.Dir != "" || args.Compare != "" || args.Inventory != "" || args.KeysFrom != "" || args.Prefix != "" || args.SQS != "") {
+		return errors.New("-latest cannot be used with -dir, -compare, -inventory, -keys-from, -prefix, or -sqs")
+	}
+	if args.Head < 0 {
+		return errors.New("-head cannot be negative")
+	}
+	if args.Head != 0 && (args.DryRun || args.PrintKeys || args.Compare != "" || args.SQS != "") {
+		return errors.New("-head cannot be used with -dry-run, -print-keys, -compare, or -sqs")
+	}
+	if args.PrintKeys && (args.DryRun || args.Compare != "") {
+		return errors.New("-print-keys cannot be used with -dry-run or -compare")
+	}
+	if args.SQS != "" && (args.Dir != "" || args.Compare != "" || args.Inventory != "" || args.KeysFrom != "" || args.DryRun || args.PrintKeys) {
+		return errors.New("-sqs cannot be used with -dir, -compare, -inventory, -keys-from, -dry-run, or -print-keys")
+	}
+	if args.SQS != "" && (args.Parallel > 1 || args.KeepGoing || args.ProgressBar || args.Tag != "") {
+		// files are loaded one by one as they are announced, and
+		// those that fail are retried on redelivery
+		return errors.New("-sqs cannot be used with -parallel, -keep-going, -progress-bar, or -tag")
+	}
+	if args.SQS != "" && (args.JSONLOut != "" || args.LogOut != "" || args.SQLDump != "" || args.Manifest != "" ||
+		args.SummaryOut != "" || args.Rollup != 0 || args.Preset != "" || args.StatsOnly) {
+		return errors.New("-sqs only fills the database, it cannot be used with -jsonl-out, -log-out, -sql-dump,\n" +
+			"-manifest, -summary-out, -rollup, -preset, or -stats-only")
+	}
+	if args.DryRun && args.Compare != "" {
+		return errors.New("-dry-run cannot be used with -compare")
+	}
+	if args.KeysFrom != "" && (args.Compare != "" || args.Inventory != "") {
+		return errors.New("-keys-from cannot be used with -compare or -inventory")
+	}
+	if args.JSONLOut != "" && args.Compare != "" {
+		return errors.New("-jsonl-out cannot be used with -compare")
+	}
+	if args.JSONLOut == "-" && (args.Preset != "" || args.StatsOnly) {
+		return errors.New("-jsonl-out - cannot be used with -preset or -stats-only, which also write to standard output")
+	}
+	if args.LogOut != "" && args.Compare != "" {
+		return errors.New("-log-out cannot be used with -compare")
+	}
+	if args.CompressLevel != gzip.DefaultCompression && (args.CompressLevel < gzip.BestSpeed || args.CompressLevel > gzip.BestCompression) {
+		return fmt.Errorf("-compress-level must be from %d to %d", gzip.BestSpeed, gzip.BestCompression)
+	}
+	if args.SQLDump != "" && args.Compare != "" {
+		return errors.New("-sql-dump cannot be used with -compare")
+	}
+	if args.SQLDump == "-" && (args.Preset != "" || args.StatsOnly || args.JSONLOut == "-") {
+		return errors.New("-sql-dump - cannot be used with -preset, -stats-only, or -jsonl-out -, which also write to standard output")
+	}
+	if args.Manifest != "" && args.Compare != "" {
+		return errors.New("-manifest cannot be used with -compare")
+	}
+	if args.Preset != "" && args.Compare != "" {
+		return errors.New("-preset cannot be used with -compare")
+	}
+	if (args.AccessKey == "") != (args.SecretKey == "") {
+		return errors.New("-access-key and -secret-key must be used together")
+	}
+	if args.Account != "" && (len(args.Account) != 12 || !hasOnlyDigits(args.Account)) {
+		return fmt.Errorf("-account must be a 12-digit AWS account id, got %q", args.Account)
+	}
+	if args.Bucket != "" && args.Dir != "" {
+		return errors.New("-bucket cannot be used with -dir")
+	}
+	if args.BucketOwner != "" && (len(args.BucketOwner) != 12 || !hasOnlyDigits(args.BucketOwner)) {
+		return fmt.Errorf("-bucket-owner must be a 12-digit AWS account id, got %q", args.BucketOwner)
+	}
+	if args.SessionToken != "" && args.AccessKey == "" {
+		return errors.New("-session-token requires -access-key and -secret-key")
+	}
+	if args.SSECustomerKey != "" {
+		k, err := parseSSECustomerKey(args.SSECustomerKey)
+		if err != nil {
+			return err
+		}
+		args.sseKey = k
+	}
+	if args.HTTPProxy != "" {
+		u, err := url.Parse(args.HTTPProxy)
+		if err != nil {
+			return fmt.Errorf("-http-proxy: %w", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("-http-proxy must be a URL like http://proxy.example.com:3128, got %q", args.HTTPProxy)
+		}
+		args.proxy = u
+	}
+	if err := prefixTemplate(args.Prefix).validate(); err != nil {
+		return err
+	}
+	if args.Inventory != "" && !strings.HasPrefix(args.Inventory, "s3://") {
+		return fmt.Errorf("-inventory must be an s3:// URL, got %q", args.Inventory)
+	}
+	if args.Tag != "" {
+		tag, err := parseTag(args.Tag)
+		if err != nil {
 			return err
 		}
-		args.time = t
+		args.tag = &tag
+	}
+	for _, s := range args.PathRules {
+		r, err := parsePathRule(s)
+		if err != nil {
+			return err
+		}
+		args.pathRules = append(args.pathRules, r)
+		args.NormalizeURL = true
+	}
+	if args.ThreatList != "" {
+		set, err := readThreatList(args.ThreatList)
+		if err != nil {
+			return fmt.Errorf("-threat-list: %w", err)
+		}
+		args.threats = set
+	}
+	if args.FieldsFile != "" {
+		fields, err := readFieldsFile(args.FieldsFile)
+		if err != nil {
+			return fmt.Errorf("-fields-file: %w", err)
+		}
+		args.fields = fields
+	}
+	if k, ok := strings.CutPrefix(args.AfterKey, "s3://"); ok {
+		// an URL as printed by -print-keys
+		_, args.AfterKey, _ = strings.Cut(k, "/")
+	}
+	if args.SSLProtocol != "" && args.fields != nil && !slices.Contains(args.fields, "ssl_protocol") {
+		return errors.New("-ssl-protocol requires the ssl_protocol field, which -fields-file doesn't list")
+	}
+	if args.Target != "" {
+		p, err := parseTarget(args.Target)
+		if err != nil {
+			return err
+		}
+		args.target = p
+	}
+	if _, err := filepath.Match(args.Glob, ""); err != nil {
+		return fmt.Errorf("invalid -glob pattern %q: %w", args.Glob, err)
+	}
+	var err error
+	if args.location, err = timeLocation(args.UTC, args.Timezone); err != nil {
+		return err
+	}
+	if args.window, err = ParseWindow(args.TimeString, args.location); err != nil {
+		return err
+	}
+	if args.Compare != "" {
+		if args.compareTime, err = parseTime(args.Compare, args.location); err != nil {
+			return fmt.Errorf("-compare: %w", err)
+		}
+		if args.Database != "" {
+			return errors.New("-compare cannot be used with -db")
+		}
+		if args.Dir != "" {
+			return errors.New("-compare cannot be used with -dir")
+		}
 	}
 	return nil
 }
 
-func run(ctx context.Context, args *runArgs, albName string) error {
+// run loads logs of load balancers targets, given as names, name@region
+// pairs, or ARNs. With -dir, the only optional target names the data set.
+func run(ctx context.Context, args *runArgs, targets []string) error {
 	if err := args.populate(); err != nil {
 		return err
 	}
-	if albName == "" {
+	if args.Inspect {
+		return inspect(ctx, args)
+	}
+	var albName string
+	if len(targets) != 0 {
+		albName = targets[0]
+	}
+	if albName == "" && args.Dir == "" {
 		return errUsage
 	}
-
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(args.Profile))
-	if err != nil {
-		return err
+	if len(targets) > 1 && (args.Dir != "" || args.Inventory != "" || args.KeysFrom != "" || args.AfterKey != "" || args.SQS != "") {
+		return errors.New("-dir, -inventory, -keys-from, -after-key, and -sqs only support a single load balancer")
 	}
-
-	s3Client := s3.NewFromConfig(cfg)
-
-	meta, err := loadMetadata(ctx, alb.NewFromConfig(cfg), albName)
-	if err != nil {
-		return err
+	if args.Healthcheck {
+		if albName == "" {
+			return errors.New("-healthcheck requires a load balancer name or ARN")
+		}
+		var failed bool
+		for _, target := range targets {
+			if len(targets) > 1 {
+				fmt.Printf("%s:\n", target)
+			}
+			if err := healthcheck(ctx, os.Stdout, args, target); err == errHealthcheckFailed {
+				failed = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if failed {
+			return errHealthcheckFailed
+		}
+		return nil
+	}
+	if args.MetadataOnly {
+		if albName == "" || args.Dir != "" || args.Bucket != "" {
+			return errors.New("-metadata-only requires a load balancer name or ARN, and cannot be used with -dir or -bucket")
+		}
+		return printMetadata(ctx, os.Stdout, args, targets)
+	}
+	// flags are only saved for runs that load logs, once they are known to
+	// be valid
+	if args.saveFlags != nil && !args.DryRun && !args.PrintConfig {
+		if err := args.saveFlags(); err != nil {
+			return err
+		}
+	}
+	if args.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, args.MaxRuntime, errMaxRuntime)
+		defer cancel()
 	}
 
 	line := new(status.Line)
 	line.SetOutput(os.Stderr)
 	defer line.Done()
 
-	fullPrefix := fullS3prefix(args.time, meta.Prefix, meta.Account, meta.Region)
-	line.Print("Fetching candidate log files list, this may take a while")
-	keys, err := candidateKeys(ctx, s3Client, meta.Bucket, fullPrefix, args.time)
+	var src *logSource
+	var err error
+	_, s := startSpan(ctx, "discover")
+	if len(targets) > 1 {
+		args.regionColumn = true
+		src, err = newMultiSource(ctx, args, targets)
+	} else {
+		src, err = newLogSource(ctx, args, albName)
+	}
+	s.done(err)
 	if err != nil {
 		return err
 	}
-	if len(keys) == 0 {
-		return fmt.Errorf("no candidate log files found, bucket %q, prefix %q", meta.Bucket, fullPrefix)
+	if args.KeysFrom != "" {
+		src.list = func(context.Context, time.Time) ([]string, error) { return readKeys(args.KeysFrom) }
+	}
+	list := src.list
+	src.list = func(ctx context.Context, t time.Time) ([]string, error) {
+		keys, err := list(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		// listing several prefixes, or a hand-written list, may
+		// yield the same key more than once
+		out := dedupeKeys(keys)
+		if n := len(keys) - len(out); n != 0 {
+			line.Print("")
+			log.Printf("Skipped %d duplicate log file keys", n)
+		}
+		return out, nil
+	}
+	if args.PrintConfig {
+		line.Print("")
+		return printConfig(os.Stdout, args, src)
+	}
+	if args.SQS != "" {
+		return consumeQueue(ctx, args, albName, src, line)
+	}
+	if args.Compare != "" {
+		return compare(ctx, args, src, line)
+	}
+	if args.Latest {
+		line.Print("Fetching the latest log files list")
+	} else if args.Dir == "" {
+		line.Printf("Fetching candidate log files list for %s, this may take a while", args.window.From.Format(timeLayout+" MST"))
+	} else {
+		line.Print("Fetching candidate log files list, this may take a while")
+	}
+	_, s = startSpan(ctx, "list")
+	keys, err := src.list(ctx, args.window.From)
+	s.set("files", len(keys))
+	s.done(err)
+	if err != nil {
+		if context.Cause(ctx) == errMaxRuntime {
+			return errMaxRuntime
+		}
+		return err
+	}
+	if args.DryRun {
+		return dryRun(ctx, args, src, keys, line)
+	}
+	if args.PrintKeys {
+		line.Print("")
+		for _, k := range keys[:min(len(keys), args.MaxSamples)] {
+			fmt.Println(src.url(k))
+		}
+		return nil
+	}
+	if args.Head != 0 {
+		line.Print("Parsing first log entries")
+		return printHead(ctx, os.Stdout, args, src, keys, line)
 	}
 
-	dbName := args.Database
-	if dbName == "" {
-		dbName = filepath.Join(tempDir(), albName+".db")
+	dbName := databaseName(args, src)
+	if args.Database == "" && !args.StatsOnly {
 		if err := os.MkdirAll(filepath.Dir(dbName), 0777); err != nil {
 			return err
 		}
 	}
-	cols := logFields()
-	db, err := sql.Open("sqlite", dbName)
+	inMemory := dbName == ":memory:"
+	ld, err := openDatabase(ctx, args, dbName, src)
 	if err != nil {
 		return err
 	}
+	db := ld.db
 	defer db.Close()
-	for _, pragma := range []string{"PRAGMA journal_mode=WAL", "PRAGMA synchronous=off"} {
-		if _, err := db.ExecContext(ctx, pragma); err != nil {
+	// with -jsonl-out - or -sql-dump -, standard output is reserved for
+	// the rows
+	streaming := args.JSONLOut == "-" || args.SQLDump == "-"
+	var jsonlFile *os.File
+	if args.JSONLOut == "-" {
+		ld.rowOut = newRowEncoder(os.Stdout, ld.columns, ld.numeric)
+	} else if args.JSONLOut != "" {
+		if jsonlFile, err = os.Create(args.JSONLOut); err != nil {
 			return err
 		}
+		defer jsonlFile.Close()
+		ld.rowOut = newRowEncoder(jsonlFile, ld.columns, ld.numeric)
 	}
-	for _, statement := range databaseSchema(cols) {
-		if _, err := db.ExecContext(ctx, statement); err != nil {
+	if args.LogOut != "" {
+		if ld.logOut, err = createEntryWriter(args.LogOut, args.CompressLevel, ld.cols); err != nil {
 			return err
 		}
+		defer ld.logOut.f.Close()
 	}
 
-	for i, k := range keys {
-		if i == args.MaxSamples {
-			break
+	res, err := loadFiles(ctx, args, ld, src, keys, line)
+	if ld.rowOut != nil {
+		if ferr := ld.rowOut.flush(); err == nil {
+			err = ferr
 		}
-		line.Printf("Processing log candidate %d", i+1)
-		if err := ingestLogFile(ctx, s3Client, meta.Bucket, k, db, cols); err != nil {
-			return fmt.Errorf("ingesting %q: %w", k, err)
+	}
+	if ld.logOut != nil {
+		if cerr := ld.logOut.close(); err == nil {
+			err = cerr
+		}
+	}
+	if jsonlFile != nil {
+		if cerr := jsonlFile.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if res.timedOut {
+		ctx = context.WithoutCancel(ctx)
+	}
+	if args.Manifest != "" {
+		line.Print("Writing manifest")
+		if err := writeManifest(ctx, args, src, dbName, res.files); err != nil {
+			return fmt.Errorf("writing manifest: %w", err)
 		}
 	}
 	_, _ = db.ExecContext(ctx, "PRAGMA optimize")
+	if res.newRows != 0 {
+		line.Print("Updating query planner statistics")
+		if _, err := db.ExecContext(ctx, "ANALYZE"); err != nil {
+			return err
+		}
+	}
+	if args.Rollup != 0 {
+		line.Print("Computing rollups")
+		if err := writeRollups(ctx, db, args.Rollup); err != nil {
+			return fmt.Errorf("computing rollups: %w", err)
+		}
+	}
+	if args.SummaryOut != "" {
+		line.Print("Writing summary")
+		if err := writeSummaryFile(ctx, args.SummaryOut, db, args.SampleRate); err != nil {
+			return fmt.Errorf("writing summary: %w", err)
+		}
+	}
+	if args.SQLDump != "" {
+		line.Print("Writing SQL dump")
+		if err := writeSQLDump(ctx, args.SQLDump, db, args.FloatPrecision); err != nil {
+			return fmt.Errorf("writing SQL dump: %w", err)
+		}
+	}
+	line.Print("")
+	if args.StatsOnly {
+		stats, err := collectStats(ctx, db, args.SampleRate)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(stats); err != nil {
+			return err
+		}
+		return res.err()
+	}
+	if args.Preset != "" {
+		// standard output is reserved for query results
+		if err := runPreset(ctx, os.Stdout, db, args.Preset, args.Format, args.FloatPrecision); err != nil {
+			return err
+		}
+	} else {
+		// with -no-shell, standard output is reserved for the database path
+		w := os.Stdout
+		if args.NoShell || streaming {
+			w = os.Stderr
+		}
+		if err := printSummary(ctx, w, db, useColor(args.Color, w)); err != nil {
+			return err
+		}
+	}
+	var totalRows int64
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM logs").Scan(&totalRows); err != nil {
+		return err
+	}
 	if err := db.Close(); err != nil {
 		return err
 	}
-	line.Print("")
+	if inMemory {
+		log.Printf("Loaded %d rows into an in-memory database, which is now discarded;\n"+
+			"use -db with a file path to keep the data for further analysis", totalRows)
+		return res.err()
+	}
+	if args.Finalize {
+		if err := finalizeDatabase(ctx, dbName); err != nil {
+			return fmt.Errorf("finalizing database: %w", err)
+		}
+	}
 	log.Print("For details on fields description see https://amzn.to/2VXnvAx")
-	log.Println("Database file:", dbName)
-	if term.IsTerminal(0) && term.IsTerminal(1) {
-		if sqlitePath, err := exec.LookPath("sqlite3"); err == nil {
-			// cmd := exec.CommandContext(ctx, sqlitePath, dbName)
-			// cmd.Stdin = os.Stdin
-			// cmd.Stdout = os.Stdout
-			// cmd.Stderr = os.Stderr
-			// return cmd.Run()
-			return syscall.Exec(sqlitePath, []string{"sqlite3", dbName}, os.Environ())
+	if fi, err := os.Stat(dbName); err == nil {
+		log.Printf("Database file: %s (%s, %d rows, %d added)", dbName, formatSize(fi.Size()), totalRows, res.newRows)
+	} else {
+		log.Println("Database file:", dbName)
+	}
+	if args.NoShell && args.Preset == "" && !streaming {
+		if p, err := filepath.Abs(dbName); err == nil {
+			dbName = p
+		}
+		fmt.Println(dbName)
+	}
+	if err := res.err(); err != nil {
+		return err
+	}
+	if args.Preset != "" || args.NoShell || streaming {
+		return nil
+	}
+	// the shell replaces the process
+	finishTrace(ctx, nil)
+	return runShell(dbName, false, args.Extensions)
+}
+
+// databaseName returns the database path: the -db one, or a file in the
+// temporary directory named after the source.
+func databaseName(args *runArgs, src *logSource) string {
+	if args.StatsOnly {
+		return ":memory:"
+	}
+	if args.Database != "" {
+		return args.Database
+	}
+	return filepath.Join(tempDir(), src.name+".db")
+}
+
+// logSource is where log files are loaded from: either an S3 bucket, or a
+// local directory.
+type logSource struct {
+	name string // load balancer or directory name
+	open openFunc
+	// list returns keys of candidate log files for the time window starting
+	// at t
+	list func(ctx context.Context, t time.Time) ([]string, error)
+	// stat returns the size and, for S3 objects, the ETag of the log file
+	stat func(ctx context.Context, key string) (fileStat, error)
+
+	s3          *s3.Client // nil for local directories
+	bucket      string
+	bucketOwner *string // expected bucket owner account id, if set
+	region      string
+	arn         string // load balancer ARN, if known
+	// prefixes returns S3 key prefixes listed for the time window starting
+	// at t; nil for local directories
+	prefixes func(t time.Time) []string
+
+	// sources, if not nil, are the load balancer sources combined by
+	// newMultiSource, keyed by their buckets; members are all of them, in
+	// the order load balancers were given
+	sources map[string]*logSource
+	members []*logSource
+}
+
+// url returns the s3://bucket/key URL of the log file identified by key, or
+// key itself for local files and keys of sources combined by newMultiSource,
+// which are URLs already.
+func (src *logSource) url(key string) string {
+	if src.sources != nil || src.s3 == nil {
+		return key
+	}
+	return "s3://" + src.bucket + "/" + key
+}
+
+// newLogSource discovers where to load log files from.
+func newLogSource(ctx context.Context, args *runArgs, albName string) (*logSource, error) {
+	if args.Dir != "" {
+		src := &logSource{name: albName, open: openLocalFile, stat: statLocalFile}
+		if src.name == "" {
+			dir, err := filepath.Abs(args.Dir)
+			if err != nil {
+				return nil, err
+			}
+			src.name = filepath.Base(dir)
+		}
+		src.list = func(context.Context, time.Time) ([]string, error) {
+			keys, err := localFiles(args.Dir, args.Glob, args.MaxDepth, args.FollowSymlinks)
+			if err == nil && len(keys) == 0 {
+				err = fmt.Errorf("%w: no files matching %q in %q", ErrNoCandidates, args.Glob, args.Dir)
+			}
+			return keys, err
+		}
+		return src, nil
+	}
+	cfg, err := awsConfig(ctx, args, albName)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := splitRegion(albName)
+	if isLoadBalancerARN(albName) {
+		name = nameFromARN(albName)
+	}
+
+	s3Client := newS3Client(cfg)
+
+	var meta *metadata
+	if args.Bucket != "" {
+		if meta, err = metadataFromFlags(args, albName); err != nil {
+			return nil, err
+		}
+	} else if meta, err = loadMetadata(ctx, alb.NewFromConfig(cfg), albName); errors.Is(err, ErrAttributesDenied) {
+		return nil, fmt.Errorf("%w\nthe load balancer is in account %s, region %s; "+
+			"give its logs bucket with -bucket, and -prefix if logs are delivered under a custom one",
+			err, meta.Account, meta.Region)
+	} else if err != nil {
+		return nil, err
+	} else if args.Account != "" {
+		meta.Account = args.Account
+	}
+	if meta.Type == "" && isLoadBalancerARN(albName) {
+		meta.Type = typeFromARN(albName)
+	}
+	if meta.Type != "" && meta.Type != string(types.LoadBalancerTypeEnumApplication) {
+		// other load balancer types write logs of different formats
+		return nil, fmt.Errorf("%w: %s is a %s load balancer, only application load balancers are supported",
+			ErrUnsupportedType, name, meta.Type)
+	}
+	if meta.Region == "" {
+		// log file keys include the region, fall back to the one
+		// resolved by SDK from environment or shared config
+		if meta.Region = cfg.Region; meta.Region == "" {
+			return nil, errors.New("cannot figure out the load balancer region, " +
+				"set AWS_REGION or configure region for the profile")
+		}
+	}
+	var owner *string
+	if args.BucketOwner != "" {
+		owner = &args.BucketOwner
+	}
+	listedPrefixes := func(t time.Time) []string {
+		end := listOptionsFor(args).windowEnd(t)
+		if args.Prefix != "" {
+			return prefixTemplate(args.Prefix).prefixes(t, end, meta.Account, meta.Region)
+		}
+		return windowPrefixes(t, end, meta.Prefix, meta.Account, meta.Region)
+	}
+	stats := new(objectStats)
+	return &logSource{
+		name: name,
+		open: s3Opener(s3Client, meta.Bucket, owner, args.sseKey, stats),
+		list: func(ctx context.Context, t time.Time) ([]string, error) {
+			opts := listOptionsFor(args)
+			opts.stats = stats
+			prefixes := listedPrefixes(t)
+			if args.tag != nil {
+				// tags are only known after listing
+				opts.limit = 0
+			}
+			var keys []string
+			var err error
+			switch {
+			case args.Inventory != "":
+				keys, err = inventoryKeys(ctx, s3Client, args.Inventory, meta.Bucket, prefixes, t, opts)
+			case args.Latest:
+				keys, err = latestKeys(ctx, s3Client, meta.Bucket, owner, logsPrefix(meta.Prefix, meta.Account, meta.Region), opts)
+			default:
+				keys, err = candidateKeys(ctx, s3Client, meta.Bucket, owner, prefixes, t, opts)
+			}
+			if err != nil || args.tag == nil {
+				return keys, err
+			}
+			if keys, err = filterByTag(ctx, s3Client, meta.Bucket, owner, keys, *args.tag, listLimit(args)); err == nil && len(keys) == 0 {
+				err = fmt.Errorf("%w: no log files tagged %s=%s", ErrNoCandidates, args.tag.key, args.tag.value)
+			}
+			return keys, err
+		},
+		stat: func(ctx context.Context, key string) (fileStat, error) {
+			if st, ok := stats.get(key); ok {
+				return st, nil
+			}
+			// keys from -keys-from are never listed
+			out, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket:               &meta.Bucket,
+				Key:                  &key,
+				ExpectedBucketOwner:  owner,
+				SSECustomerAlgorithm: args.sseKey.algorithm(),
+				SSECustomerKey:       args.sseKey.keyValue(),
+				SSECustomerKeyMD5:    args.sseKey.keyMD5(),
+			})
+			if err != nil {
+				return fileStat{}, err
+			}
+			return fileStat{size: aws.ToInt64(out.ContentLength), etag: aws.ToString(out.ETag)}, nil
+		},
+		s3:          s3Client,
+		bucket:      meta.Bucket,
+		bucketOwner: owner,
+		region:      meta.Region,
+		arn:         meta.ARN,
+		prefixes:    listedPrefixes,
+	}, nil
+}
+
+// openDatabase opens the database, initializing its schema, and returns the
+// loader for it.
+func openDatabase(ctx context.Context, args *runArgs, dbName string, src *logSource) (*loader, error) {
+	cols := logFields(args)
+	db, err := sql.Open("sqlite", dbName)
+	if err != nil {
+		return nil, err
+	}
+	// a database in the temporary directory is disposable, so trade
+	// durability for speed; one the user asked for by name should survive
+	// a crash, unless -fast says otherwise
+	synchronous := "PRAGMA synchronous=normal"
+	if args.Database == "" || args.Fast {
+		synchronous = "PRAGMA synchronous=off"
+	}
+	pragmas := []string{"PRAGMA journal_mode=WAL", synchronous}
+	if dbName == ":memory:" {
+		// each connection to :memory: opens a new empty database
+		db.SetMaxOpenConns(1)
+		pragmas = pragmas[1:]
+	}
+	for _, pragma := range pragmas {
+		if _, err := db.ExecContext(ctx, pragma); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	derived := derivedColumns(cols)
+	if args.SourceColumn {
+		derived = append(derived, sourceKeyColumn)
+	}
+	if args.regionColumn {
+		derived = append(derived, regionColumn)
+	}
+	if args.threats != nil {
+		derived = append(derived, clientFlaggedColumn(cols, args.threats))
+	}
+	aliases := columnAliases
+	if args.RawColumns {
+		aliases = nil
+	}
+	if args.Truncate {
+		// run_meta describes loaded entries, so it goes away with them
+		for _, table := range []string{"logs", "s3objects", "partial_objects", "run_meta"} {
+			if _, err := db.ExecContext(ctx, `DROP TABLE IF EXISTS `+table); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+	}
+	existing, err := tableColumnList(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if len(existing) != 0 {
+		// naming of columns in an existing database is kept as is
+		switch ok := slices.Contains(existing, sourceKeyColumn.name); {
+		case ok && !args.SourceColumn:
+			derived = append(derived, sourceKeyColumn)
+		case !ok && args.SourceColumn:
+			db.Close()
+			return nil, fmt.Errorf("database %s was created without -add-source-column, cannot add it", dbName)
+		}
+		if !args.regionColumn && slices.Contains(existing, regionColumn.name) {
+			derived = append(derived, regionColumn)
+		}
+	}
+	// once added, the column is filled on every load
+	if args.NormalizeURL || slices.Contains(existing, "request_path_normalized") {
+		derived = append(derived, normalizedPathColumn(cols, append(slices.Clip(args.pathRules), builtinPathRules...)))
+	}
+	defs := schemaColumns(cols, aliases, derived, args.TimeMS)
+	// with -include-columns-from-db, the existing table is left as is
+	keepSchema := args.KeepSchema && len(existing) != 0
+	var keep []bool
+	if keepSchema {
+		var skipped []string
+		if defs, keep, skipped = intersectColumns(existing, defs); len(skipped) != 0 {
+			log.Printf("Database %s has no columns for %s, not loading them", dbName, strings.Join(skipped, ", "))
+		}
+	} else if err := migrateSchema(ctx, db, existing, defs); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("database %s: %w", dbName, err)
+	}
+	for _, statement := range databaseSchema(defs) {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	// a table kept as is may lack columns of the current schema version
+	if !keepSchema {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version=%d", schemaVersion)); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	columns, err := insertColumns(ctx, db, defs)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("database %s: %w", dbName, err)
+	}
+	if err := recordSampleRate(ctx, db, args.SampleRate); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := recordTimeUnit(ctx, db, args.TimeMS, len(existing) != 0); err != nil {
+		db.Close()
+		return nil, err
+	}
+	ld := &loader{
+		db:         db,
+		cols:       cols,
+		columns:    columns,
+		numeric:    numericColumns(defs),
+		derived:    derived,
+		keep:       keep,
+		open:       src.open,
+		sampleRate: args.SampleRate,
+		seed:       args.Seed,
+
+		commitEvery: args.CommitEvery,
+	}
+	if args.NoHealthChecks {
+		ld.filters = append(ld.filters, excludeHealthChecks(cols))
+	}
+	if args.MinStatus != 0 || args.MaxStatus != 0 {
+		ld.filters = append(ld.filters, statusFilter(cols, args.MinStatus, args.MaxStatus))
+	}
+	if args.target.IsValid() {
+		ld.filters = append(ld.filters, targetFilter(cols, args.target))
+	}
+	if args.SSLProtocol != "" {
+		ld.filters = append(ld.filters, sslProtocolFilter(cols, strings.Split(args.SSLProtocol, ",")))
+	}
+	if args.TimeMS {
+		ld.converters = make(map[int]func(string) any)
+		for i, col := range cols {
+			if isProcessingTime(col) {
+				ld.converters[i] = milliseconds
+			}
 		}
 	}
+	return ld, nil
+}
+
+// finalizeDatabase turns the closed database from the WAL mode it's populated
+// in back to the default rollback journal mode, so that it's a single
+// self-contained file without -wal and -shm companions.
+func finalizeDatabase(ctx context.Context, dbName string) error {
+	db, err := sql.Open("sqlite", dbName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// switching the journal mode requires the only connection
+	db.SetMaxOpenConns(1)
+	for _, pragma := range []string{"PRAGMA wal_checkpoint(TRUNCATE)", "PRAGMA journal_mode=DELETE"} {
+		if _, err := db.ExecContext(ctx, pragma); err != nil {
+			return err
+		}
+	}
+	return db.Close()
+}
+
+// loadResult describes the outcome of loadFiles.
+type loadResult struct {
+	newRows  int          // number of rows added
+	timedOut bool         // whether loading was cut short by -max-runtime
+	files    []fileResult // successfully processed files, in completion order
+	failed   []string     // keys of files skipped on errors with -keep-going
+}
+
+// err returns the error the program should exit with once loaded data is
+// reported: either errMaxRuntime, or one listing failed files.
+func (res loadResult) err() error {
+	if res.timedOut {
+		return errMaxRuntime
+	}
+	if len(res.failed) != 0 {
+		return fmt.Errorf("%w: %d, see errors above:\n\t%s", errFilesFailed, len(res.failed), strings.Join(res.failed, "\n\t"))
+	}
 	return nil
 }
 
-func logFields() []string { return strings.Split(strings.TrimSpace(fieldsFile), "\n") }
+// loadFiles loads up to args.MaxSamples log files identified by keys into the
+// database.
+func loadFiles(ctx context.Context, args *runArgs, ld *loader, src *logSource, keys []string, line *status.Line) (loadResult, error) {
+	var res loadResult
+	var loaded int // number of processed files
+	var archived []archivedObject
+	var loadErr error
+	var pb *progressBar
+	if args.ProgressBar {
+		pb = newProgressBar(line, min(len(keys), args.MaxSamples))
+		defer func(open openFunc) { ld.open = open }(ld.open)
+		ld.open = pb.wrap(ld.open)
+	}
+	// handle accounts for a single processed file, returning false if
+	// loading must stop
+	handle := func(r fileResult) bool {
+		res.newRows += r.rows
+		if r.err != nil {
+			if context.Cause(ctx) == errMaxRuntime {
+				// only rows of the partially processed file
+				// added since its last commit are lost
+				res.timedOut = true
+				return false
+			}
+			if e := (*s3types.InvalidObjectState)(nil); errors.As(r.err, &e) {
+				archived = append(archived, archivedObject{key: r.key, class: e.StorageClass})
+				return true
+			}
+			if args.KeepGoing {
+				line.Print("")
+				log.Printf("Skipping %s: %v", r.key, r.err)
+				res.failed = append(res.failed, r.key)
+				return true
+			}
+			loadErr = fmt.Errorf("ingesting %q: %w", r.key, r.err)
+			return false
+		}
+		loaded++
+		res.files = append(res.files, r)
+		if pb != nil {
+			pb.fileDone(r.key)
+		}
+		return true
+	}
+	if args.Parallel > 1 {
+		line.Printf("Processing log candidates with %d workers", args.Parallel)
+		ld.ingestParallel(ctx, keys, args.Parallel, args.DecodeWorkers, args.MaxSamples, func(r fileResult) bool {
+			ok := handle(r)
+			if pb == nil {
+				line.Printf("Processed %d log candidates", loaded)
+			}
+			return ok
+		})
+	} else {
+		for _, k := range keys {
+			if loaded == args.MaxSamples {
+				break
+			}
+			if pb == nil {
+				line.Printf("Processing log candidate %d", loaded+1)
+			}
+			ctx, s := startSpan(ctx, "ingest")
+			s.set("key", k)
+			n, err := ld.ingestLogFile(ctx, k)
+			s.set("rows", n)
+			s.done(err)
+			if !handle(fileResult{key: k, rows: n, err: err}) {
+				break
+			}
+		}
+	}
+	if loadErr != nil {
+		return res, loadErr
+	}
+	if len(archived) != 0 {
+		line.Print("")
+		names := make([]string, len(archived))
+		for i, o := range archived {
+			names[i] = o.key
+		}
+		log.Printf("Skipped %d log files in archival storage:\n\t%s", len(archived), strings.Join(names, "\n\t"))
+		if args.Restore && !res.timedOut {
+			for _, o := range archived {
+				line.Printf("Requesting restoration of %s", path.Base(o.key))
+				s, key, err := src.locate(o.key)
+				if err == nil {
+					err = restoreObject(ctx, s.s3, s.bucket, s.bucketOwner, archivedObject{key: key, class: o.class})
+				}
+				if err != nil {
+					return res, fmt.Errorf("restoring %q: %w", o.key, err)
+				}
+			}
+			line.Print("")
+			log.Print("Restoration requested, re-run the program once it completes, which may take hours")
+		} else if !args.Restore {
+			log.Print("Use -restore to request their restoration")
+		}
+	}
+	return res, nil
+}
 
-func ingestLogFile(ctx context.Context, client *s3.Client, bucket, key string, db *sql.DB, cols []string) error {
-	alreadyImported := func(ctx context.Context, db interface {
-		QueryRowContext(context.Context, string, ...any) *sql.Row
-	}, key string) bool {
-		var sink int
-		_ = db.QueryRowContext(ctx, `SELECT 1 FROM s3objects WHERE basename=?`, path.Base(key)).Scan(&sink)
-		return sink == 1
+// inspect prints the summary of an already populated database, then starts an
+// interactive shell on it.
+func inspect(ctx context.Context, args *runArgs) error {
+	if args.Database == "" || args.Database == ":memory:" {
+		return errors.New("-inspect requires -db pointing to an existing database file")
 	}
-	if alreadyImported(ctx, db, key) {
+	if _, err := os.Stat(args.Database); err != nil {
+		return err
+	}
+	dbPath, err := filepath.Abs(args.Database)
+	if err != nil {
+		return err
+	}
+	// open read-only, so that a mistyped path does not create an empty database
+	db, err := sql.Open("sqlite", (&url.URL{Scheme: "file", Path: dbPath, RawQuery: "mode=ro"}).String())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if rows, err := db.QueryContext(ctx, `SELECT key, value FROM run_meta ORDER BY key`); err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var k, v string
+			if err := rows.Scan(&k, &v); err != nil {
+				return err
+			}
+			log.Printf("%s: %s", k, v)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	if args.Preset != "" {
+		return runPreset(ctx, os.Stdout, db, args.Preset, args.Format, args.FloatPrecision)
+	}
+	if err := printSummary(ctx, os.Stdout, db, useColor(args.Color, os.Stdout)); err != nil {
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	log.Println("Database file:", args.Database)
+	if args.NoShell {
 		return nil
 	}
-	obj, err := client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
+	return runShell(args.Database, true, args.Extensions)
+}
+
+// runShell replaces the current process with the sqlite3 shell opened on
+// dbName, if both standard input and output are connected to a terminal and
+// sqlite3 is installed. Otherwise it does nothing, except for suggesting
+// alternatives on a terminal without sqlite3. The shell loads extensions
+// before reading any input.
+func runShell(dbName string, readOnly bool, extensions []string) error {
+	if !term.IsTerminal(0) || !term.IsTerminal(1) {
+		return nil
+	}
+	sqlitePath, err := exec.LookPath("sqlite3")
+	if err != nil {
+		log.Print("The sqlite3 shell is not found in PATH, install it to explore the database interactively\n" +
+			"(e.g. apt install sqlite3, or brew install sqlite), open the database with any other SQLite client,\n" +
+			"or use -preset to print results of common queries")
+		return nil
+	}
+	argv := []string{"sqlite3"}
+	if readOnly {
+		argv = append(argv, "-readonly")
+	}
+	for _, ext := range extensions {
+		// the shell takes arguments in single quotes verbatim
+		argv = append(argv, "-cmd", ".load '"+ext+"'")
+	}
+	return syscall.Exec(sqlitePath, append(argv, dbName), os.Environ())
+}
+
+// logFields returns names of log entry fields, in the order they appear in
+// entries: either read from -fields-file, or the embedded list.
+func logFields(args *runArgs) []string {
+	if args.fields != nil {
+		return args.fields
+	}
+	return strings.Split(strings.TrimSpace(fieldsFile), "\n")
+}
+
+// readFieldsFile reads the list of log entry fields in the fields.txt format:
+// one field name per line.
+func readFieldsFile(name string) ([]string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	for _, s := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		s = strings.TrimSpace(s)
+		if s == "" || strings.IndexFunc(s, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_')
+		}) != -1 {
+			return nil, fmt.Errorf("%s: invalid field name %q, must only have lowercase letters, digits, and underscores", name, s)
+		}
+		if slices.Contains(fields, s) {
+			return nil, fmt.Errorf("%s: duplicate field %q", name, s)
+		}
+		fields = append(fields, s)
+	}
+	if len(fields) < minLogFields {
+		return nil, fmt.Errorf("%s: lists %d fields, every log entry has at least %d", name, len(fields), minLogFields)
+	}
+	return fields, nil
+}
+
+// openFunc opens the log file identified by key for reading.
+type openFunc func(ctx context.Context, key string) (io.ReadCloser, error)
+
+// s3Opener returns openFunc fetching log files from the S3 bucket. If sse is
+// not nil, log files are expected to be encrypted with this customer-provided
+// key. Sizes and ETags of fetched objects are recorded in stats.
+//
+// Log files are always fetched whole. S3 Select could project a subset of
+// fields server-side, but it is not available to new AWS customers since
+// 2024, and its CSV parser does not handle the log format reliably: fields
+// are separated by spaces and quoted only sometimes, and the quoted ones
+// may contain escaped quotes. There's also no way to project fields without
+// knowing the exact layout of a particular file in advance.
+func s3Opener(client *s3.Client, bucket string, owner *string, sse *sseCustomerKey, stats *objectStats) openFunc {
+	return func(ctx context.Context, key string) (io.ReadCloser, error) {
+		obj, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:               &bucket,
+			Key:                  &key,
+			ExpectedBucketOwner:  owner,
+			SSECustomerAlgorithm: sse.algorithm(),
+			SSECustomerKey:       sse.keyValue(),
+			SSECustomerKeyMD5:    sse.keyMD5(),
+		})
+		if err != nil {
+			return nil, explainAccessDenied(ctx, client, bucket, key, owner, err)
+		}
+		stats.add(key, fileStat{size: aws.ToInt64(obj.ContentLength), etag: aws.ToString(obj.ETag)})
+		return obj.Body, nil
+	}
+}
+
+// archivedObject is an S3 object that must be restored before it can be read.
+type archivedObject struct {
+	key   string
+	class s3types.StorageClass
+}
+
+// restoreDays is how long restored copies of archived log files are kept.
+const restoreDays = 3
+
+// restoreObject requests restoration of an archived object. A request for an
+// object that is already being restored is not an error.
+func restoreObject(ctx context.Context, client *s3.Client, bucket string, owner *string, o archivedObject) error {
+	req := &s3types.RestoreRequest{}
+	// objects in Intelligent-Tiering archive tiers are moved back to the
+	// frequent access tier and don't take any parameters
+	if o.class != s3types.StorageClassIntelligentTiering {
+		req.Days = aws.Int32(restoreDays)
+		req.GlacierJobParameters = &s3types.GlacierJobParameters{Tier: s3types.TierStandard}
+	}
+	_, err := client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:              &bucket,
+		Key:                 &o.key,
+		RestoreRequest:      req,
+		ExpectedBucketOwner: owner,
 	})
+	if e := smithy.APIError(nil); errors.As(err, &e) && e.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return err
+}
+
+// loader loads log files into the database.
+type loader struct {
+	db      *sql.DB
+	cols    []string // log fields, in the order they appear in log entries
+	columns []string // table columns for cols, followed by derived ones
+	numeric []bool   // whether columns have numeric types
+	derived []derivedColumn
+	// keep, if not nil, tells which values of parsed rows, fields followed
+	// by derived ones, have columns; others are dropped, see
+	// intersectColumns
+	keep    []bool
+	filters []rowFilter // entries are only loaded if all filters accept them
+	open    openFunc
+	// converters, keyed by field index, turn field values into values
+	// stored in the database, overriding the default conversion
+	converters map[int]func(string) any
+
+	sampleRate float64 // probability of keeping each entry
+	seed       uint64  // random seed used for sampling
+
+	// commitEvery, if positive, is the number of rows after which the
+	// transaction is committed and a new one started
+	commitEvery int
+
+	rowOut *rowEncoder  // if not nil, receives every loaded row
+	logOut *entryWriter // if not nil, receives every loaded entry as is
+}
+
+// ingestLogFile loads a single gzip-compressed log file into the database,
+// returning the number of added rows. Files that were already loaded are
+// skipped.
+//
+// If l.commitEvery is set, rows are committed in chunks, together with the
+// number of lines processed so far, recorded in the partial_objects table.
+// Loading of a file interrupted midway then resumes after the last committed
+// line, so no rows are added twice. On error, the returned number is that of
+// rows committed before the error.
+//
+// With tracing, the download span covers opening the file, the parse span
+// reading, parsing, and adding of its rows, as the file is streamed, and
+// insert spans cover each commit.
+func (l *loader) ingestLogFile(ctx context.Context, key string) (int, error) {
+	db := l.db
+	if alreadyImported(ctx, db, key) {
+		return 0, nil
+	}
+	skip := l.resumeLine(ctx, key)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// tx is replaced on chunked commits
+	defer func() { tx.Rollback() }()
+
+	if alreadyImported(ctx, tx, key) {
+		return 0, nil
+	}
+
+	query := insertStatement(l.columns)
+	st, err := tx.PrepareContext(ctx, query)
 	if err != nil {
+		return 0, err
+	}
+	defer func() { st.Close() }()
+	var rows, committed int
+	var out pendingOutput // of rows not yet committed
+	// commit writes rows added so far along with the progress marker, then
+	// starts a new transaction
+	commit := func(lineNo int) error {
+		_, s := startSpan(ctx, "insert")
+		s.set("rows", rows-committed)
+		err := markPartial(ctx, tx, key, lineNo)
+		if err == nil {
+			st.Close()
+			err = tx.Commit()
+		}
+		s.done(err)
+		if err != nil {
+			return err
+		}
+		committed = rows
+		if err := l.flushOutput(&out); err != nil {
+			return err
+		}
+		if tx, err = db.BeginTx(ctx, nil); err != nil {
+			return err
+		}
+		st, err = tx.PrepareContext(ctx, query)
 		return err
 	}
-	defer obj.Body.Close()
-	gr, err := gzip.NewReader(obj.Body)
+	_, s := startSpan(ctx, "download")
+	rc, err := l.open(ctx, key)
+	s.done(err)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	_, s = startSpan(ctx, "parse")
+	err = l.decode(rc, key, skip, &out, func(row []any, lineNo int) error {
+		if _, err := st.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+		rows++
+		if l.commitEvery > 0 && rows-committed == l.commitEvery {
+			return commit(lineNo)
+		}
+		return nil
+	})
+	s.set("rows", rows)
+	s.done(err)
+	if err != nil {
+		return committed, err
+	}
+	_, s = startSpan(ctx, "insert")
+	s.set("rows", rows-committed)
+	err = markImported(ctx, tx, key)
+	if err == nil {
+		err = tx.Commit()
+	}
+	s.done(err)
 	if err != nil {
+		return committed, err
+	}
+	return rows, l.flushOutput(&out)
+}
+
+// pendingOutput holds -log-out entries and -jsonl-out rows collected by
+// decode, to be written with flushOutput once their rows are committed.
+type pendingOutput struct {
+	entries []byte // see entryWriter.appendEntry
+	rows    []byte // see rowEncoder.appendRow
+}
+
+// flushOutput writes -log-out entries and -jsonl-out rows of committed rows,
+// and empties out.
+func (l *loader) flushOutput(out *pendingOutput) error {
+	if l.logOut != nil && len(out.entries) != 0 {
+		err := l.logOut.write(out.entries)
+		out.entries = out.entries[:0]
+		if err != nil {
+			return fmt.Errorf("-log-out: %w", err)
+		}
+	}
+	if l.rowOut != nil && len(out.rows) != 0 {
+		err := l.rowOut.write(out.rows)
+		out.rows = out.rows[:0]
+		if err != nil {
+			return fmt.Errorf("-jsonl-out: %w", err)
+		}
+	}
+	return nil
+}
+
+// resumeLine returns the number of lines of the log file that were committed
+// by an earlier interrupted run, see markPartial.
+func (l *loader) resumeLine(ctx context.Context, key string) int {
+	var n int
+	_ = l.db.QueryRowContext(ctx, `SELECT lines FROM partial_objects WHERE basename=?`, path.Base(key)).Scan(&n)
+	return n
+}
+
+// markPartial records that rows from the first lines of the log file are
+// committed to the database.
+func markPartial(ctx context.Context, tx *sql.Tx, key string, lines int) error {
+	_, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO partial_objects VALUES(?,?)`, path.Base(key), lines)
+	return err
+}
+
+// markImported records that the log file is fully loaded.
+func markImported(ctx context.Context, tx *sql.Tx, key string) error {
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS s3objects(basename TEXT PRIMARY KEY)`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO s3objects VALUES(?)`, path.Base(key)); err != nil {
 		return err
 	}
-	defer gr.Close()
+	_, err := tx.ExecContext(ctx, `DELETE FROM partial_objects WHERE basename=?`, path.Base(key))
+	return err
+}
 
-	rd := csv.NewReader(gr)
-	rd.FieldsPerRecord = len(cols)
-	rd.Comma = ' '
-	rd.ReuseRecord = true
+// parse reads the log file, calling emit with values of each row to insert
+// and the number of the line the row comes from. The first skip lines are
+// read, but not emitted. The row slice is reused between calls, so emit must
+// copy it to keep rows around; a shallow copy is enough, as values in it are
+// never reused: strings are fresh copies of each line's text, not views into
+// a shared buffer.
+func (l *loader) parse(ctx context.Context, key string, skip int, emit func(row []any, lineNo int) error) error {
+	rc, err := l.open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return l.decode(rc, key, skip, nil, emit)
+}
 
-	tx, err := db.BeginTx(ctx, nil)
+// decode works like parse, reading the log file identified by key from r,
+// decompressed as decompressedReader does.
+//
+// With -log-out and -jsonl-out, entries and rows that are emitted are added to
+// out, unless it's nil, to be written with flushOutput once the rows are
+// committed.
+func (l *loader) decode(r io.Reader, key string, skip int, out *pendingOutput, emit func(row []any, lineNo int) error) error {
+	cols := l.cols
+	text, err := decompressedReader(r, key)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
-	if alreadyImported(ctx, tx, key) {
-		return nil
-	}
+	sc := bufio.NewScanner(text)
+	sc.Buffer(make([]byte, 0, 64*1024), maxLineSize)
 
-	st, err := tx.PrepareContext(ctx, insertStatement(cols))
-	if err != nil {
-		return err
+	fields := make([]string, 0, len(cols))
+	insertArgs := make([]any, 0, len(cols)+len(l.derived))
+	// entry is reused across rows to avoid allocations
+	entry := logEntry{key: key}
+	var width int // number of fields per entry, taken from the first one
+	var rng *rand.Rand
+	if l.sampleRate < 1 {
+		// seeding with the file name makes the sample independent of
+		// the order files are loaded in
+		h := fnv.New64a()
+		h.Write([]byte(path.Base(key)))
+		rng = rand.New(rand.NewPCG(l.seed, h.Sum64()))
 	}
-	defer st.Close()
-	var insertArgs []any
-	for {
-		fields, err := rd.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		// sc.Text allocates a new string, field values are
+		// substrings of it and stay valid after the next Scan
+		if fields, err = splitLine(fields[:0], sc.Text()); err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if width == 0 {
+			if len(fields) < minLogFields {
+				return fmt.Errorf("line %d: wrong number of fields: got %d, want at least %d", lineNo, len(fields), minLogFields)
 			}
-			return err
+			width = len(fields)
+			if note := layoutNote(width, cols); note != "" {
+				log.Printf("%s: %s", path.Base(key), note)
+			}
+		}
+		if len(fields) != width {
+			return fmt.Errorf("line %d: wrong number of fields: got %d, previous entries had %d", lineNo, len(fields), width)
+		}
+		entry.fields = fields
+		if !l.accept(&entry) {
+			continue
+		}
+		if rng != nil && rng.Float64() >= l.sampleRate {
+			continue
+		}
+		if lineNo <= skip {
+			// filters and sampling above still run for these
+			// lines, so the random sequence stays the same
+			continue
+		}
+		if l.logOut != nil && out != nil {
+			out.entries = l.logOut.appendEntry(out.entries, fields)
 		}
 		insertArgs = insertArgs[:0]
-		for _, v := range fields {
+		for i, v := range fields[:min(width, len(cols))] {
+			if fn, ok := l.converters[i]; ok {
+				insertArgs = append(insertArgs, fn(v))
+				continue
+			}
 			if hasOnlyDigits(v) {
 				if x, err := strconv.ParseUint(v, 10, 64); err == nil {
 					insertArgs = append(insertArgs, x)
@@ -257,59 +1850,112 @@ func ingestLogFile(ctx context.Context, client *s3.Client, bucket, key string, d
 			}
 			insertArgs = append(insertArgs, v)
 		}
-		if _, err := st.ExecContext(ctx, insertArgs...); err != nil {
+		for len(insertArgs) < len(cols) {
+			insertArgs = append(insertArgs, nil)
+		}
+		for _, dc := range l.derived {
+			insertArgs = append(insertArgs, dc.value(&entry))
+		}
+		if l.keep != nil {
+			n := 0
+			for i, v := range insertArgs {
+				if l.keep[i] {
+					insertArgs[n] = v
+					n++
+				}
+			}
+			insertArgs = insertArgs[:n]
+		}
+		if l.rowOut != nil && out != nil {
+			if out.rows, err = l.rowOut.appendRow(out.rows, insertArgs); err != nil {
+				return fmt.Errorf("-jsonl-out: %w", err)
+			}
+		}
+		if err := emit(insertArgs, lineNo); err != nil {
 			return err
 		}
 	}
-	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS s3objects(basename TEXT PRIMARY KEY)`); err != nil {
-		return err
-	}
-	if _, err := tx.ExecContext(ctx, `INSERT INTO s3objects VALUES(?)`, path.Base(key)); err != nil {
-		return err
+	return sc.Err()
+}
+
+// accept reports whether entry passes all filters.
+func (l *loader) accept(e *logEntry) bool {
+	for _, fn := range l.filters {
+		if !fn(e) {
+			return false
+		}
 	}
-	return tx.Commit()
+	return true
+}
+
+// alreadyImported reports whether the log file identified by key was loaded
+// before.
+func alreadyImported(ctx context.Context, db interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}, key string) bool {
+	var sink int
+	_ = db.QueryRowContext(ctx, `SELECT 1 FROM s3objects WHERE basename=?`, path.Base(key)).Scan(&sink)
+	return sink == 1
 }
 
-// databaseSchema returns SQL statements initializing database
-func databaseSchema(cols []string) []string {
+// databaseSchema returns SQL statements initializing database with the logs
+// table having columns defs, see schemaColumns.
+func databaseSchema(defs []columnDef) []string {
 	var out []string
 
 	b := new(strings.Builder)
 	b.WriteString("create table if not exists logs(\n")
-	for i, col := range cols {
-		var colType string
-		switch col {
-		case "elb_status_code", "target_status_code",
-			"received_bytes", "sent_bytes",
-			"matched_rule_priority":
-			colType = "INTEGER"
-		case "request_processing_time", "target_processing_time", "response_processing_time":
-			colType = "REAL"
-		}
-		b.WriteString("    '")
-		b.WriteString(col)
-		b.WriteByte('\'')
-		if colType != "" {
-			b.WriteByte(' ')
-			b.WriteString(colType)
-		}
-		if i != len(cols)-1 {
+	for i, c := range defs {
+		b.WriteString("    ")
+		b.WriteString(c.String())
+		if i != len(defs)-1 {
 			b.WriteByte(',')
 		}
 		b.WriteByte('\n')
 	}
 	b.WriteByte(')')
 	out = append(out, b.String())
+	out = append(out, `create table if not exists run_meta(key TEXT PRIMARY KEY, value)`)
+	out = append(out, `create table if not exists partial_objects(basename TEXT PRIMARY KEY, lines INTEGER)`)
 	return out
 }
 
-// insertStatement returns an INSERT SQL statement
-func insertStatement(cols []string) string {
+// recordSampleRate saves the sample rate into the run_meta table. Since rows
+// loaded at different rates cannot be meaningfully aggregated together, it
+// refuses to reuse a database populated with a different rate.
+func recordSampleRate(ctx context.Context, db *sql.DB, rate float64) error {
+	var prev float64
+	err := db.QueryRowContext(ctx, `SELECT value FROM run_meta WHERE key='sample_rate'`).Scan(&prev)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = db.ExecContext(ctx, `INSERT INTO run_meta VALUES('sample_rate', ?)`, rate)
+		return err
+	case err != nil:
+		return err
+	case prev != rate:
+		return fmt.Errorf("database was populated with sample rate %v, cannot add entries sampled at %v", prev, rate)
+	}
+	return nil
+}
+
+// insertStatement returns an INSERT SQL statement for the named columns.
+// Naming columns explicitly keeps values aligned with them regardless of the
+// table column order.
+func insertStatement(columns []string) string {
 	b := new(strings.Builder)
-	b.WriteString("insert or ignore into logs values(\n")
-	for i := range cols {
+	b.WriteString("insert or ignore into logs(")
+	for i, name := range columns {
+		b.WriteByte('"')
+		b.WriteString(name)
+		b.WriteByte('"')
+		if i != len(columns)-1 {
+			b.WriteByte(',')
+		}
+	}
+	b.WriteString(") values(\n")
+	for i := range columns {
 		b.WriteByte('?')
-		if i != len(cols)-1 {
+		if i != len(columns)-1 {
 			b.WriteByte(',')
 		}
 	}
@@ -325,117 +1971,603 @@ func accountAndRegion(arn string) (account, region string, err error) {
 	return fields[4], fields[3], nil
 }
 
+// awsConfig loads AWS SDK configuration according to args. If albName is a
+// load balancer ARN, the configuration uses the load balancer region.
+//
+// All clients made from the configuration, S3 and load balancer API ones
+// alike, use the SDK standard retryer, backing off exponentially with full
+// jitter, with the number of attempts set by -retries.
+func awsConfig(ctx context.Context, args *runArgs, albName string) (aws.Config, error) {
+	cfgOpts := []func(*config.LoadOptions) error{
+		config.WithSharedConfigProfile(args.Profile),
+		config.WithRetryMaxAttempts(args.Retries + 1),
+	}
+	if args.AccessKey != "" {
+		log.Print("Using static credentials from command line flags, " +
+			"consider using a profile or environment variables instead")
+		cfgOpts = append(cfgOpts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(args.AccessKey, args.SecretKey, args.SessionToken)))
+	}
+	if args.HTTPProxy != "" || args.InsecureSkipVerify || args.HTTPTimeout != 0 {
+		cfgOpts = append(cfgOpts, config.WithHTTPClient(httpClient(args)))
+	}
+
+	if isLoadBalancerARN(albName) {
+		// API calls must go to the load balancer region, and its logs
+		// bucket is always in the same region
+		_, region, err := accountAndRegion(albName)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		cfgOpts = append(cfgOpts, config.WithRegion(region))
+	} else if _, region := splitRegion(albName); region != "" {
+		cfgOpts = append(cfgOpts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, cfgOpts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	refreshExpiredCredentials(&cfg)
+	return cfg, nil
+}
+
+// newS3Client returns the S3 client for cfg. Load balancers upload log files
+// without checksums, so the SDK is told not to log that it can't validate
+// each download.
+func newS3Client(cfg aws.Config) *s3.Client {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) { o.DisableLogOutputChecksumValidationSkipped = true })
+}
+
+// httpClient returns the HTTP client for AWS API calls and trace exports,
+// customized according to -http-proxy, -insecure-skip-verify, and
+// -http-timeout. Without -http-proxy, proxy is taken from HTTPS_PROXY and
+// related environment variables.
+//
+// The timeout covers connecting, the TLS handshake, and waiting for response
+// headers, but not reading response bodies: downloads of large log files over
+// slow links may legitimately take long.
+func httpClient(args *runArgs) *awshttp.BuildableClient {
+	c := awshttp.NewBuildableClient()
+	if args.HTTPTimeout != 0 {
+		c = c.WithDialerOptions(func(d *net.Dialer) { d.Timeout = args.HTTPTimeout })
+	}
+	return c.WithTransportOptions(func(tr *http.Transport) {
+		if args.HTTPTimeout != 0 {
+			tr.TLSHandshakeTimeout = args.HTTPTimeout
+			tr.ResponseHeaderTimeout = args.HTTPTimeout
+		}
+		if args.proxy != nil {
+			tr.Proxy = http.ProxyURL(args.proxy)
+		}
+		if args.InsecureSkipVerify {
+			log.Print("TLS certificate verification is disabled with -insecure-skip-verify")
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = new(tls.Config)
+			}
+			tr.TLSClientConfig.InsecureSkipVerify = true
+		}
+	})
+}
+
+// refreshExpiredCredentials makes requests failing because of expired
+// credentials retried with freshly retrieved ones. Credentials are cached
+// until shortly before their reported expiration time, but long runs may still
+// hit an expired token: for example, when a session is revoked, or when clocks
+// are skewed.
+func refreshExpiredCredentials(cfg *aws.Config) {
+	cache, ok := cfg.Credentials.(*aws.CredentialsCache)
+	if !ok {
+		return
+	}
+	cfg.Retryer = func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.Retryables = append(o.Retryables, retry.IsErrorRetryableFunc(func(err error) aws.Ternary {
+				var e smithy.APIError
+				if errors.As(err, &e) {
+					switch e.ErrorCode() {
+					case "ExpiredToken", "ExpiredTokenException", "RequestExpired":
+						cache.Invalidate()
+						return aws.TrueTernary
+					}
+				}
+				return aws.UnknownTernary
+			}))
+		})
+	}
+}
+
+// isLoadBalancerARN reports whether s is a load balancer ARN, such as
+// arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/my-lb/50dc6c495c0c9188
+func isLoadBalancerARN(s string) bool {
+	fields := strings.SplitN(s, ":", 6)
+	return len(fields) == 6 && fields[0] == "arn" && fields[2] == "elasticloadbalancing" &&
+		strings.HasPrefix(fields[5], "loadbalancer/")
+}
+
+// typeFromARN returns the load balancer type from its ARN, as reported by the
+// DescribeLoadBalancers API call, or an empty string if it's unknown.
+func typeFromARN(arn string) string {
+	// resource part is loadbalancer/app/name/id
+	parts := strings.Split(arn[strings.LastIndexByte(arn, ':')+1:], "/")
+	if len(parts) != 4 {
+		return ""
+	}
+	switch parts[1] {
+	case "app":
+		return string(types.LoadBalancerTypeEnumApplication)
+	case "net":
+		return string(types.LoadBalancerTypeEnumNetwork)
+	case "gwy":
+		return string(types.LoadBalancerTypeEnumGateway)
+	}
+	return ""
+}
+
+// nameFromARN returns the load balancer name from its ARN.
+func nameFromARN(arn string) string {
+	// resource part is loadbalancer/app/name/id
+	parts := strings.Split(arn[strings.LastIndexByte(arn, ':')+1:], "/")
+	if len(parts) == 4 {
+		return parts[2]
+	}
+	return parts[len(parts)-1]
+}
+
 // https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#access-log-file-format
 func fullS3prefix(t time.Time, prefix, account, region string) string {
-	return path.Join(prefix, "AWSLogs", account, "elasticloadbalancing", region, t.UTC().Format("2006/01/02"))
+	return path.Join(logsPrefix(prefix, account, region), t.UTC().Format("2006/01/02"))
 }
 
-func candidateKeys(ctx context.Context, client *s3.Client, bucket, fullPrefix string, refTime time.Time) ([]string, error) {
-	p := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
-		Bucket: &bucket,
-		Prefix: &fullPrefix,
-	})
-	notAfter := refTime.Add(5 * time.Minute)
-	var out []string
-	for p.HasMorePages() {
-		page, err := p.NextPage(ctx)
+// metadataFromFlags returns the load balancer logs setup given by -bucket and
+// -account, for runs that skip discovery: the load balancer name is only used
+// as a label. The region is empty unless it's known from albName or the
+// metadata cache.
+func metadataFromFlags(args *runArgs, albName string) (*metadata, error) {
+	meta := &metadata{Bucket: args.Bucket, Account: args.Account}
+	if isLoadBalancerARN(albName) {
+		var err error
+		meta.ARN = albName
+		if meta.Account == "" {
+			meta.Account, meta.Region, err = accountAndRegion(albName)
+		} else {
+			_, meta.Region, err = accountAndRegion(albName)
+		}
 		if err != nil {
 			return nil, err
 		}
-		for _, obj := range page.Contents {
-			if obj.LastModified == nil || obj.Key == nil || !strings.HasSuffix(*obj.Key, ".log.gz") {
-				continue
+	} else {
+		_, meta.Region = splitRegion(albName)
+		if cached, ok := cachedMetadata(albName); ok {
+			// discovered by a run denied access to attributes
+			meta.Type, meta.ARN = cached.Type, cached.ARN
+			if meta.Account == "" {
+				meta.Account = cached.Account
 			}
-			if t := *obj.LastModified; t.Before(refTime) || t.After(notAfter) {
-				continue
+			if meta.Region == "" {
+				meta.Region = cached.Region
+			}
+		}
+	}
+	if meta.Account == "" && (args.Prefix == "" || strings.Contains(args.Prefix, "{account}")) {
+		return nil, errors.New("-bucket requires -account to build log file keys, " +
+			"unless the load balancer is given by ARN or -prefix doesn't use {account}")
+	}
+	return meta, nil
+}
+
+// listedPrefix returns the key prefix all log files of the load balancer
+// are under: the standard one of meta, or the part of -prefix before its
+// first date placeholder.
+func listedPrefix(args *runArgs, meta *metadata) string {
+	if args.Prefix != "" {
+		return prefixTemplate(args.Prefix).static(meta.Account, meta.Region)
+	}
+	return logsPrefix(meta.Prefix, meta.Account, meta.Region) + "/"
+}
+
+// logsPrefix returns the S3 prefix of all log files of the load balancer,
+// which are under its yyyy/mm/dd subprefixes.
+func logsPrefix(prefix, account, region string) string {
+	return path.Join(prefix, "AWSLogs", account, "elasticloadbalancing", region)
+}
+
+// dedupeKeys returns keys with duplicates removed, keeping the first
+// occurrence of each key in place.
+func dedupeKeys(keys []string) []string {
+	seen := make(map[string]struct{}, len(keys))
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		out = append(out, k)
+	}
+	return out
+}
+
+// readKeys returns log file keys listed in the file, one per line. Empty lines
+// and lines starting with # are ignored.
+func readKeys(name string) ([]string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, s := range strings.Split(string(b), "\n") {
+		if s = strings.TrimSpace(s); s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		keys = append(keys, s)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %s lists no keys", ErrNoCandidates, name)
+	}
+	return keys, nil
+}
+
+// listLimit returns the number of candidate keys worth listing, 0 meaning all
+// of them. Keys beyond -n are used in place of archived files, but lifecycle
+// rules archive files by age, so files of the same time window are rarely
+// archived selectively. With -keep-going, they also replace files that fail
+// to load, so all candidates are listed. The -dry-run mode reports the full
+// number of candidates.
+func listLimit(args *runArgs) int {
+	if args.DryRun || args.KeepGoing {
+		return 0
+	}
+	return args.MaxSamples
+}
+
+// listOptionsFor returns options of listing candidate log files according to
+// args.
+func listOptionsFor(args *runArgs) listOptions {
+	return listOptions{
+		minSize:     args.MinSize,
+		maxSize:     args.MaxSize,
+		keySubstr:   args.KeySubstring,
+		afterKey:    args.AfterKey,
+		perPrefix:   args.PerPrefix,
+		limit:       listLimit(args),
+		window:      args.window.Duration(),
+		deliveryLag: args.DeliveryLag,
+		keyTime:     args.KeyTimeFilter,
+	}
+}
+
+// listOptions narrow down the list of candidate log files.
+type listOptions struct {
+	// minSize is the minimum object size; smaller objects, such as empty
+	// placeholders, are skipped
+	minSize int64
+	// maxSize, if positive, is the maximum object size; larger objects
+	// are skipped with a warning
+	maxSize int64
+	// keySubstr, if not empty, is the text keys must contain after their
+	// date prefix: file names include load balancer node IP address, so
+	// this can select logs of a single node
+	keySubstr string
+	// afterKey, if not empty, is the key all accepted keys must sort
+	// after
+	afterKey string
+	// perPrefix, if positive, is the maximum number of keys taken from a
+	// single date prefix
+	perPrefix int
+	// limit, if positive, is the maximum number of keys needed: listing
+	// stops once it's reached, so busy buckets don't need keys of every
+	// object kept in memory
+	limit int
+	// window is the length of the delivery time window
+	window time.Duration
+	// deliveryLag extends the window end, see windowEnd
+	deliveryLag time.Duration
+	// keyTime makes files selected by the time in their names rather than
+	// by LastModified, see delivered
+	keyTime bool
+	// stats, if not nil, records sizes and ETags of accepted objects
+	stats *objectStats
+}
+
+// windowEnd returns the end of the delivery time window starting at from.
+//
+// Log files are selected by their delivery time (LastModified), while entries
+// in them carry request times. ALB delivers a file every five minutes with
+// the requests of the preceding interval, but delivery may lag by a few more
+// minutes, so the window end can be extended with -delivery-lag to pick such
+// files too. Request times of loaded entries are not filtered by the window.
+func (o listOptions) windowEnd(from time.Time) time.Time { return from.Add(o.window + o.deliveryLag) }
+
+// windowPrefixes returns full S3 prefixes of all days the half-open
+// [from, to) interval spans, see fullS3prefix.
+func windowPrefixes(from, to time.Time, prefix, account, region string) []string {
+	var out []string
+	for d := from.UTC().Truncate(24 * time.Hour); d.Before(to); d = d.AddDate(0, 0, 1) {
+		out = append(out, fullS3prefix(d, prefix, account, region))
+	}
+	return out
+}
+
+// candidateKeys returns keys of log files under prefixes that were delivered
+// within the half-open [refTime, opts.windowEnd(refTime)) interval, filtered
+// according to opts.
+//
+// When there's more than one prefix, keys are taken from each of them in
+// turn, so that the first files of the list are spread evenly over all days.
+//
+// S3 reports LastModified with a one second precision, so refTime is
+// truncated to a whole second: otherwise a file delivered within the same
+// second as refTime, but before its fractional part, would be dropped.
+func candidateKeys(ctx context.Context, client *s3.Client, bucket string, owner *string, prefixes []string, refTime time.Time, opts listOptions) ([]string, error) {
+	from := refTime.Truncate(time.Second)
+	to := opts.windowEnd(from)
+	groups := make([][]string, len(prefixes))
+	for i, fullPrefix := range prefixes {
+		input := &s3.ListObjectsV2Input{
+			Bucket:              &bucket,
+			Prefix:              &fullPrefix,
+			ExpectedBucketOwner: owner,
+		}
+		if opts.afterKey != "" {
+			// S3 skips earlier keys without listing them
+			input.StartAfter = &opts.afterKey
+		}
+		p := s3.NewListObjectsV2Paginator(client, input)
+	pages:
+		for p.HasMorePages() {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, obj := range page.Contents {
+				if obj.LastModified == nil || obj.Key == nil {
+					continue
+				}
+				if !opts.delivered(*obj.Key, *obj.LastModified, from, to) {
+					continue
+				}
+				if !opts.accept(*obj.Key, fullPrefix, aws.ToInt64(obj.Size)) {
+					continue
+				}
+				opts.stats.add(*obj.Key, fileStat{size: aws.ToInt64(obj.Size), etag: aws.ToString(obj.ETag)})
+				groups[i] = append(groups[i], *obj.Key)
+				// keys are taken from every prefix in turn, so
+				// none of them contributes more than limit
+				if len(groups[i]) == opts.perPrefix || len(groups[i]) == opts.limit {
+					break pages
+				}
+			}
+		}
+	}
+	return interleaveKeys(groups, bucket, prefixes, opts)
+}
+
+// delivered reports whether the log file with the key and the LastModified
+// time was delivered within the half-open [from, to) interval. With
+// opts.keyTime, the time in the file name is used instead of LastModified,
+// unless the name doesn't have it.
+func (o listOptions) delivered(key string, modified, from, to time.Time) bool {
+	if o.keyTime {
+		if t, ok := keyTime(key); ok {
+			modified = t
+		}
+	}
+	return !modified.Before(from) && modified.Before(to)
+}
+
+// keyTime returns the time in the name of the log file, when the load
+// balancer wrote it, with a minute precision. Names have the
+// account_elasticloadbalancing_region_lb_20240102T0305Z_ip_random.log.gz
+// format.
+func keyTime(key string) (time.Time, bool) {
+	parts := strings.SplitN(path.Base(key), "_", 6)
+	if len(parts) < 6 || parts[1] != "elasticloadbalancing" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("20060102T1504Z", parts[4])
+	return t, err == nil
+}
+
+// accept reports whether the object with the key under fullPrefix and having
+// the size is a log file matching the options. Besides .log.gz files, as AWS
+// writes them, files with suffixes of registered decompressors are accepted.
+func (o listOptions) accept(key, fullPrefix string, size int64) bool {
+	if !strings.HasSuffix(key, ".log.gz") && decompressorFor(key) == nil {
+		return false
+	}
+	if size < o.minSize || key <= o.afterKey {
+		return false
+	}
+	if o.keySubstr != "" && !strings.Contains(strings.TrimPrefix(key, fullPrefix), o.keySubstr) {
+		return false
+	}
+	if o.maxSize > 0 && size > o.maxSize {
+		log.Printf("Skipping %s: its size of %s is over -max-file-size", key, formatSize(size))
+		return false
+	}
+	return true
+}
+
+// interleaveKeys returns keys taken from each group in turn, at most
+// opts.limit of them. Groups hold keys found under the respective prefixes of
+// the bucket. If all groups are empty, it returns an error wrapping
+// ErrNoCandidates.
+func interleaveKeys(groups [][]string, bucket string, prefixes []string, opts listOptions) ([]string, error) {
+	var total int
+	for _, g := range groups {
+		total += len(g)
+	}
+	if total == 0 {
+		where := fmt.Sprintf("bucket %q, prefix %q", bucket, strings.Join(prefixes, `", "`))
+		if opts.keySubstr != "" {
+			where += fmt.Sprintf(", keys containing %q", opts.keySubstr)
+		}
+		if opts.afterKey != "" {
+			where += fmt.Sprintf(", keys after %q", opts.afterKey)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNoCandidates, where)
+	}
+	if opts.limit > 0 {
+		total = min(total, opts.limit)
+	}
+	out := make([]string, 0, total)
+	for i := 0; len(out) < total; i++ {
+		for _, g := range groups {
+			if i < len(g) && len(out) < total {
+				out = append(out, g[i])
 			}
-			out = append(out, *obj.Key)
 		}
 	}
 	return out, nil
 }
 
 // loadMetadata either returns load balancer logs setup from the local cache,
-// or discovers it over AWS API, saving results to persistent cache.
-func loadMetadata(ctx context.Context, albClient *alb.Client, albName string) (*metadata, error) {
+// or discovers it over AWS API, saving results to persistent cache. The load
+// balancer is cached under target, which may include the @region suffix, as
+// load balancers in different regions may have the same name.
+//
+// If access to load balancer attributes is denied, it returns what it could
+// discover, without the bucket, along with an error wrapping
+// ErrAttributesDenied. Such partial results are cached too, so that they
+// need not be discovered again, see cachedMetadata.
+func loadMetadata(ctx context.Context, albClient *alb.Client, target string) (*metadata, error) {
 	cacheFile := filepath.Join(cacheDir(), "alblogs-cache.json")
-	var fullCache map[string]metadata
-	b, err := os.ReadFile(cacheFile)
-	if err == nil {
-		if err := json.Unmarshal(b, &fullCache); err == nil {
-			if meta, ok := fullCache[albName]; ok {
-				return &meta, nil
-			}
-		}
+	fullCache := readMetadataCache(cacheFile)
+	cached, ok := fullCache[target]
+	if ok && cached.Bucket != "" {
+		return &cached, nil
 	}
+	albName, _ := splitRegion(target)
 
 	var meta metadata
 
-	descResult, err := albClient.DescribeLoadBalancers(ctx, &alb.DescribeLoadBalancersInput{
-		Names: []string{albName},
-	})
-	if err != nil {
-		var notFound *types.LoadBalancerNotFoundException
-		if errors.As(err, &notFound) {
+	var albARN string
+	if cached.ARN != "" {
+		// partially discovered before
+		albARN = cached.ARN
+		meta.Type = cached.Type
+	} else if isLoadBalancerARN(albName) {
+		// no need to look up the ARN, which also saves a permission
+		albARN = albName
+		meta.Type = typeFromARN(albARN)
+	} else {
+		descResult, err := albClient.DescribeLoadBalancers(ctx, &alb.DescribeLoadBalancersInput{
+			Names: []string{albName},
+		})
+		if err != nil {
+			var notFound *types.LoadBalancerNotFoundException
+			if errors.As(err, &notFound) {
+				if known, _ := partialMatches(ctx, albClient, albName); len(known) != 0 {
+					return nil, fmt.Errorf("cannot find load balancer %q, did you mean one of these?\n\t%s",
+						albName, strings.Join(known, "\n\t"))
+				}
+			}
+			return nil, err
+		}
+		for _, lb := range descResult.LoadBalancers {
+			if lb.LoadBalancerName != nil && *lb.LoadBalancerName == albName {
+				albARN = *lb.LoadBalancerArn
+				meta.Type = string(lb.Type)
+				break
+			}
+		}
+		if albARN == "" {
 			if known, _ := partialMatches(ctx, albClient, albName); len(known) != 0 {
-				return nil, fmt.Errorf("cannot find load balancer %q, here's the list of partial matches:\n\t%s",
+				return nil, fmt.Errorf("cannot figure out ARN of load balancer %q, did you mean one of these?\n\t%s",
 					albName, strings.Join(known, "\n\t"))
 			}
+			return nil, errors.New("cannot figure out load balancer ARN")
 		}
-		return nil, err
-	}
-	var albARN string
-	for _, lb := range descResult.LoadBalancers {
-		if lb.LoadBalancerName != nil && *lb.LoadBalancerName == albName {
-			albARN = *lb.LoadBalancerArn
-			break
-		}
-	}
-	if albARN == "" {
-		return nil, errors.New("cannot figure out load balancer ARN")
 	}
 
+	meta.ARN = albARN
+	var err error
+	if meta.Account, meta.Region, err = accountAndRegion(albARN); err != nil {
+		return nil, err
+	}
 	attrResult, err := albClient.DescribeLoadBalancerAttributes(ctx, &alb.DescribeLoadBalancerAttributesInput{
 		LoadBalancerArn: &albARN,
 	})
+	if e := smithy.APIError(nil); errors.As(err, &e) && strings.HasPrefix(e.ErrorCode(), "AccessDenied") {
+		if fullCache == nil {
+			fullCache = make(map[string]metadata)
+		}
+		fullCache[target] = meta
+		writeMetadataCache(cacheFile, fullCache)
+		return &meta, fmt.Errorf("%w: %w", ErrAttributesDenied, err)
+	}
 	if err != nil {
 		return nil, err
 	}
-	for _, attr := range attrResult.Attributes {
+	if meta.Bucket, meta.Prefix, err = logsLocation(attrResult.Attributes); err != nil {
+		return nil, err
+	}
+	if fullCache == nil {
+		fullCache = make(map[string]metadata)
+	}
+	fullCache[target] = meta
+	writeMetadataCache(cacheFile, fullCache)
+	return &meta, nil
+}
+
+// cachedMetadata returns what loadMetadata saved about the load balancer,
+// which may be partial, lacking the bucket.
+func cachedMetadata(target string) (metadata, bool) {
+	meta, ok := readMetadataCache(filepath.Join(cacheDir(), "alblogs-cache.json"))[target]
+	return meta, ok
+}
+
+func readMetadataCache(name string) map[string]metadata {
+	var fullCache map[string]metadata
+	if b, err := os.ReadFile(name); err == nil {
+		_ = json.Unmarshal(b, &fullCache)
+	}
+	return fullCache
+}
+
+func writeMetadataCache(name string, fullCache map[string]metadata) {
+	if b, err := json.Marshal(fullCache); err == nil {
+		_ = os.MkdirAll(filepath.Dir(name), 0777)
+		_ = os.WriteFile(name, b, 0666)
+	}
+}
+
+// logsLocation returns the bucket and prefix access logs are delivered to,
+// as configured by load balancer attributes.
+func logsLocation(attrs []types.LoadBalancerAttribute) (bucket, prefix string, err error) {
+	for _, attr := range attrs {
 		if attr.Key == nil || attr.Value == nil {
 			continue
 		}
 		if *attr.Key == "access_logs.s3.enabled" && *attr.Value != "true" {
-			return nil, errors.New("load balancer has S3 logging disabled")
+			return "", "", ErrLoggingDisabled
 		}
 		switch *attr.Key {
 		case "access_logs.s3.bucket":
-			meta.Bucket = *attr.Value
+			bucket = *attr.Value
 		case "access_logs.s3.prefix":
-			meta.Prefix = *attr.Value
+			prefix = *attr.Value
 		}
 	}
-	if meta.Bucket == "" {
-		return nil, errors.New("cannot figure out which S3 bucket is used for logs")
-	}
-	if meta.Account, meta.Region, err = accountAndRegion(albARN); err != nil {
-		return nil, err
-	}
-	if fullCache == nil {
-		fullCache = make(map[string]metadata)
-	}
-	fullCache[albName] = meta
-	if b, err := json.Marshal(fullCache); err == nil {
-		_ = os.MkdirAll(filepath.Dir(cacheFile), 0777)
-		_ = os.WriteFile(cacheFile, b, 0666)
+	if bucket == "" {
+		return "", "", ErrBucketUnknown
 	}
-	return &meta, nil
+	return bucket, prefix, nil
 }
 
+// partialMatches returns names of load balancers that either contain
+// partialName, or are similar to it, as measured by the edit distance. Names
+// containing partialName come first, followed by similar ones, most similar
+// first.
 func partialMatches(ctx context.Context, svc *alb.Client, partialName string) ([]string, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	var out []string
+	type match struct {
+		name     string
+		distance int
+	}
+	var matches []match
+	// allow about one typo per 4 characters
+	maxDistance := max(2, len(partialName)/4)
 	var marker *string
 	for {
 		res, err := svc.DescribeLoadBalancers(ctx, &alb.DescribeLoadBalancersInput{Marker: marker})
@@ -443,8 +2575,16 @@ func partialMatches(ctx context.Context, svc *alb.Client, partialName string) ([
 			return nil, err
 		}
 		for _, lb := range res.LoadBalancers {
-			if lb.LoadBalancerName != nil && strings.Contains(*lb.LoadBalancerName, partialName) {
-				out = append(out, *lb.LoadBalancerName)
+			if lb.LoadBalancerName == nil {
+				continue
+			}
+			name := *lb.LoadBalancerName
+			if strings.Contains(name, partialName) {
+				matches = append(matches, match{name: name})
+				continue
+			}
+			if d := editDistance(strings.ToLower(name), strings.ToLower(partialName)); d <= maxDistance {
+				matches = append(matches, match{name: name, distance: d})
 			}
 		}
 		if res.NextMarker == nil {
@@ -452,14 +2592,46 @@ func partialMatches(ctx context.Context, svc *alb.Client, partialName string) ([
 		}
 		marker = res.NextMarker
 	}
+	slices.SortStableFunc(matches, func(a, b match) int { return a.distance - b.distance })
+	out := make([]string, len(matches))
+	for i, m := range matches {
+		out[i] = m.name
+	}
 	return out, nil
 }
 
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
 type metadata struct {
 	Account string
 	Region  string
 	Bucket  string
 	Prefix  string
+	// Type is the load balancer type: application, network, or gateway;
+	// empty in caches written by older versions of the program
+	Type string
+	// ARN is the load balancer ARN; empty in caches written by older
+	// versions of the program
+	ARN string `json:",omitempty"`
 }
 
 func cacheDir() string {
@@ -472,6 +2644,20 @@ func cacheDir() string {
 
 func tempDir() string { return filepath.Join(os.TempDir(), "alblogs") }
 
+// formatSize returns n bytes as a human-readable string.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func hasOnlyDigits(s string) bool {
 	if len(s) == 0 {
 		return false
@@ -488,13 +2674,73 @@ func hasOnlyDigits(s string) bool {
 
 func init() {
 	flag.Usage = func() {
-		fmt.Fprintln(flag.CommandLine.Output(), "Usage: alblogs [flags] load-balancer-name")
-		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: alblogs [flags] load-balancer-name[@region]|load-balancer-arn ...")
+		printDefaults(flag.CommandLine.Output())
+		fmt.Fprintln(flag.CommandLine.Output(), "\nExit status is 2 on invalid usage, 3 if -max-runtime is exceeded,\n"+
+			"4 if load balancer logging is disabled, 5 if its log bucket cannot be found,\n"+
+			"6 if no log files matched, and 1 on any other error.")
 	}
 }
 
+// hiddenFlags are not shown in usage: they are meant for diagnostics rather
+// than everyday use.
+var hiddenFlags = []string{"benchmark"}
+
+// printDefaults works like flag.PrintDefaults, skipping hiddenFlags.
+func printDefaults(w io.Writer) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(w)
+	flag.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(hiddenFlags, f.Name) {
+			return
+		}
+		fs.Var(f.Value, f.Name, f.Usage)
+		// values may have already been set from the command line
+		fs.Lookup(f.Name).DefValue = f.DefValue
+	})
+	fs.PrintDefaults()
+}
+
 var errUsage = errors.New("invalid usage")
 
+var errFilesFailed = errors.New("log files failed to load")
+
+var errMaxRuntime = errors.New("maximum run time exceeded, not all log files were loaded")
+
+var (
+	// ErrLoggingDisabled is returned when the load balancer does not have
+	// access logging to S3 enabled.
+	ErrLoggingDisabled = errors.New("load balancer has S3 logging disabled")
+	// ErrUnsupportedType is returned for load balancers other than
+	// application ones, since their logs have different formats.
+	ErrUnsupportedType = errors.New("unsupported load balancer type")
+	// ErrBucketUnknown is returned when the S3 bucket the load balancer
+	// writes logs to cannot be discovered.
+	ErrBucketUnknown = errors.New("cannot figure out which S3 bucket is used for logs")
+	// ErrNoCandidates is returned when no log files match the requested time
+	// window or filters.
+	ErrNoCandidates = errors.New("no candidate log files found")
+
+	// ErrAttributesDenied is returned when load balancer attributes,
+	// which tell where logs are delivered to, cannot be read.
+	ErrAttributesDenied = errors.New("access to load balancer attributes denied")
+)
+
+// exitCode returns the process exit status for err.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errMaxRuntime):
+		return 3
+	case errors.Is(err, ErrLoggingDisabled):
+		return 4
+	case errors.Is(err, ErrBucketUnknown):
+		return 5
+	case errors.Is(err, ErrNoCandidates):
+		return 6
+	}
+	return 1
+}
+
 //go:embed fields.txt
 var fieldsFile string
 
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileStat describes a log file as reported by logSource.stat.
+type fileStat struct {
+	size int64
+	etag string // empty for local files
+}
+
+// objectStats remembers sizes and ETags of S3 objects seen when listing or
+// downloading them, so that logSource.stat needs no extra requests for them.
+// Methods of a nil objectStats do nothing.
+type objectStats struct {
+	mu sync.Mutex
+	m  map[string]fileStat
+}
+
+func (c *objectStats) add(key string, st fileStat) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]fileStat)
+	}
+	c.m[key] = st
+}
+
+func (c *objectStats) get(key string) (fileStat, bool) {
+	if c == nil {
+		return fileStat{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.m[key]
+	return st, ok
+}
+
+// manifest is the -manifest output, describing the log files processed by a
+// single run.
+type manifest struct {
+	Source      string         `json:"source"`             // load balancer or directory name
+	Database    string         `json:"database,omitempty"` // empty for in-memory databases
+	WindowStart *time.Time     `json:"window_start,omitempty"`
+	WindowEnd   *time.Time     `json:"window_end,omitempty"`
+	Files       []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag,omitempty"`
+	Rows int    `json:"rows"` // rows added by this run, 0 if loaded before
+}
+
+// writeManifest writes the manifest of files loaded into the dbName database
+// to the file named by args.Manifest. The time window is only recorded for
+// logs loaded from S3, since local directories are not filtered by time.
+// Sizes and ETags of S3 objects are those seen when listing or downloading
+// them, see objectStats.
+func writeManifest(ctx context.Context, args *runArgs, src *logSource, dbName string, files []fileResult) error {
+	m := manifest{Source: src.name, Files: make([]manifestFile, 0, len(files))}
+	if dbName != ":memory:" {
+		if p, err := filepath.Abs(dbName); err == nil {
+			dbName = p
+		}
+		m.Database = dbName
+	}
+	if args.Dir == "" {
+		from, to := args.window.From.UTC(), args.window.To.Add(args.DeliveryLag).UTC()
+		m.WindowStart, m.WindowEnd = &from, &to
+	}
+	for _, f := range files {
+		st, err := src.stat(ctx, f.key)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, manifestFile{Key: f.key, Size: st.size, ETag: st.etag, Rows: f.rows})
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(args.Manifest, append(b, '\n'), 0666)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	alb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+)
+
+// metadataRecord is what -metadata-only prints for each load balancer.
+type metadataRecord struct {
+	LoadBalancer string `json:"load_balancer"`
+	Type         string `json:"type,omitempty"`
+	Account      string `json:"account"`
+	Region       string `json:"region"`
+	Bucket       string `json:"bucket"`
+	Prefix       string `json:"prefix"`
+}
+
+// printMetadata writes where logs of each of the targets are delivered to,
+// as loadMetadata discovers it, in the -format format: a table, CSV with a
+// header, or an indented JSON object per load balancer.
+func printMetadata(ctx context.Context, w io.Writer, args *runArgs, targets []string) error {
+	var records []metadataRecord
+	for _, target := range targets {
+		cfg, err := awsConfig(ctx, args, target)
+		if err != nil {
+			return err
+		}
+		meta, err := loadMetadata(ctx, alb.NewFromConfig(cfg), target)
+		if err != nil {
+			return fmt.Errorf("%s: %w", target, err)
+		}
+		records = append(records, metadataRecord{
+			LoadBalancer: target,
+			Type:         meta.Type,
+			Account:      meta.Account,
+			Region:       meta.Region,
+			Bucket:       meta.Bucket,
+			Prefix:       meta.Prefix,
+		})
+	}
+	if args.Format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	header := []string{"load_balancer", "type", "account", "region", "bucket", "prefix"}
+	if args.Format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		for _, r := range records {
+			cw.Write(r.fields())
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range records {
+		fmt.Fprintln(tw, strings.Join(r.fields(), "\t"))
+	}
+	return tw.Flush()
+}
+
+func (r metadataRecord) fields() []string {
+	return []string{r.LoadBalancer, r.Type, r.Account, r.Region, r.Bucket, r.Prefix}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// splitRegion splits the load balancer given as name@region into its name
+// and region. If there's no region suffix, region is empty. ARNs are returned
+// as is, as they already include the region.
+func splitRegion(target string) (name, region string) {
+	name, region, _ = strings.Cut(target, "@")
+	return name, region
+}
+
+// newMultiSource returns a logSource combining S3 logs of several load
+// balancers, possibly in different regions. Keys of its log files are
+// s3://bucket/key URLs, so that files of different buckets don't clash.
+func newMultiSource(ctx context.Context, args *runArgs, targets []string) (*logSource, error) {
+	multi := &logSource{sources: make(map[string]*logSource)}
+	var names []string
+	var sources []*logSource
+	for _, target := range targets {
+		src, err := newLogSource(ctx, args, target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", target, err)
+		}
+		if _, ok := multi.sources[src.bucket]; !ok {
+			// load balancers may share the bucket, any of them will do
+			multi.sources[src.bucket] = src
+		}
+		sources = append(sources, src)
+		names = append(names, src.name)
+	}
+	multi.name = strings.Join(names, "+")
+	multi.members = sources
+	multi.list = func(ctx context.Context, t time.Time) ([]string, error) {
+		// keys are taken from each load balancer in turn, so that the
+		// first files of the list are spread evenly over all of them
+		groups := make([][]string, len(sources))
+		var total int
+		for i, src := range sources {
+			keys, err := src.list(ctx, t)
+			if errors.Is(err, ErrNoCandidates) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", src.name, err)
+			}
+			for _, k := range keys {
+				groups[i] = append(groups[i], "s3://"+src.bucket+"/"+k)
+			}
+			total += len(keys)
+		}
+		if total == 0 {
+			return nil, fmt.Errorf("%w: none of load balancers %s", ErrNoCandidates, strings.Join(names, ", "))
+		}
+		out := make([]string, 0, total)
+		for i := 0; len(out) < total; i++ {
+			for _, g := range groups {
+				if i < len(g) {
+					out = append(out, g[i])
+				}
+			}
+		}
+		return out, nil
+	}
+	multi.open = func(ctx context.Context, key string) (io.ReadCloser, error) {
+		src, key, err := multi.locate(key)
+		if err != nil {
+			return nil, err
+		}
+		return src.open(ctx, key)
+	}
+	multi.stat = func(ctx context.Context, key string) (fileStat, error) {
+		src, key, err := multi.locate(key)
+		if err != nil {
+			return fileStat{}, err
+		}
+		return src.stat(ctx, key)
+	}
+	return multi, nil
+}
+
+// locate returns the source the log file identified by key comes from, along
+// with its key within that source. For sources other than those returned by
+// newMultiSource, these are src and key themselves.
+func (src *logSource) locate(key string) (*logSource, string, error) {
+	if src.sources == nil {
+		return src, key, nil
+	}
+	bucket, k, ok := strings.Cut(strings.TrimPrefix(key, "s3://"), "/")
+	if s, found := src.sources[bucket]; ok && found {
+		return s, k, nil
+	}
+	return nil, "", fmt.Errorf("unexpected log file %q", key)
+}
+
+// regionColumn holds the region of the load balancer an entry comes from,
+// added when loading logs of several load balancers. The region is taken from
+// the log file name, which has the
+// account_elasticloadbalancing_region_... format.
+var regionColumn = derivedColumn{
+	name:    "region",
+	sqlType: "TEXT",
+	value: func(e *logEntry) any {
+		parts := strings.SplitN(path.Base(e.key), "_", 4)
+		if len(parts) < 4 || parts[1] != "elasticloadbalancing" {
+			return nil
+		}
+		return parts[2]
+	},
+}
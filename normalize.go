@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// pathRule replaces request path segments fully matching re with
+// placeholder.
+type pathRule struct {
+	re          *regexp.Regexp
+	placeholder string
+}
+
+// builtinPathRules collapse the most common kinds of identifiers found in
+// request paths. Rules are tried in order, the first matching one wins.
+var builtinPathRules = []pathRule{
+	{regexp.MustCompile(`^[0-9]+$`), "{id}"},
+	{regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`), "{uuid}"},
+	{regexp.MustCompile(`^[0-9a-fA-F]{16,}$`), "{hex}"},
+}
+
+// parsePathRule parses the -path-rule value, which is a regular expression
+// optionally followed by = and the placeholder, {param} by default. The
+// expression must match the whole path segment.
+func parsePathRule(s string) (pathRule, error) {
+	expr, placeholder := s, "{param}"
+	if i := strings.LastIndexByte(s, '='); i != -1 {
+		expr, placeholder = s[:i], s[i+1:]
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return pathRule{}, fmt.Errorf("-path-rule %q: %w", s, err)
+	}
+	if placeholder == "" || strings.Contains(placeholder, "/") {
+		return pathRule{}, fmt.Errorf("-path-rule %q: placeholder must be non-empty and cannot contain /", s)
+	}
+	return pathRule{re: re, placeholder: placeholder}, nil
+}
+
+// normalizePath returns the path of the request URL with segments matching
+// any of rules replaced with their placeholders. Query string is dropped.
+func normalizePath(url string, rules []pathRule) string {
+	// URLs are absolute: scheme://host:port/path?query
+	if _, rest, ok := strings.Cut(url, "://"); ok {
+		if i := strings.IndexByte(rest, '/'); i != -1 {
+			url = rest[i:]
+		} else {
+			url = "/"
+		}
+	}
+	if i := strings.IndexAny(url, "?#"); i != -1 {
+		url = url[:i]
+	}
+	segments := strings.Split(url, "/")
+	for i, seg := range segments {
+		if seg == "" {
+			continue
+		}
+		if j := slices.IndexFunc(rules, func(r pathRule) bool { return r.re.MatchString(seg) }); j != -1 {
+			segments[i] = rules[j].placeholder
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// normalizedPathColumn returns the request_path_normalized column, added with
+// -normalize-url, holding the request path normalized with rules.
+func normalizedPathColumn(cols []string, rules []pathRule) derivedColumn {
+	request := slices.Index(cols, "request")
+	return derivedColumn{
+		name:    "request_path_normalized",
+		sqlType: "TEXT",
+		value: func(e *logEntry) any {
+			// request is "METHOD URL PROTOCOL"
+			_, rest, ok := strings.Cut(e.field(request), " ")
+			if !ok {
+				return nil
+			}
+			if i := strings.LastIndexByte(rest, ' '); i != -1 {
+				rest = rest[:i]
+			}
+			return normalizePath(rest, rules)
+		},
+	}
+}
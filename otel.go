@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tracer collects spans of a run and exports them to an OpenTelemetry
+// collector over OTLP/HTTP with JSON encoding, see -otel-endpoint. Spans are
+// kept in memory and exported at once when the run completes: there are only
+// a few of them per log file.
+type tracer struct {
+	args     *runArgs // for httpClient, populated by the time spans are exported
+	endpoint string
+	traceID  [16]byte
+	root     *span
+	once     sync.Once
+
+	mu    sync.Mutex // guards spans, and attributes and ends of all spans
+	spans []*span    // ended spans
+}
+
+// span is a timed operation of a run. Methods of a nil span do nothing, so
+// that code doesn't need to check whether tracing is enabled.
+type span struct {
+	tr     *tracer
+	id     [8]byte
+	parent [8]byte // zero for the root span
+	name   string
+	start  time.Time
+	end    time.Time
+	attrs  []otlpAttr
+	err    error
+}
+
+type spanKey struct{}
+
+// exportTimeout limits how long exporting spans may take, so that an
+// unresponsive collector doesn't hold up the end of the run.
+const exportTimeout = 10 * time.Second
+
+// withTracing calls fn with ctx carrying the root span of the run, then ends
+// the span and exports spans to the OTLP/HTTP collector at
+// args.OTelEndpoint. If it's empty, fn is called with ctx as is.
+func withTracing(ctx context.Context, args *runArgs, fn func(context.Context) error) error {
+	if args.OTelEndpoint == "" {
+		return fn(ctx)
+	}
+	tr := &tracer{args: args, endpoint: args.OTelEndpoint}
+	_, _ = rand.Read(tr.traceID[:])
+	tr.root = &span{tr: tr, name: "alblogs", start: time.Now()}
+	_, _ = rand.Read(tr.root.id[:])
+	err := fn(context.WithValue(ctx, spanKey{}, tr.root))
+	tr.finish(err)
+	return err
+}
+
+// finishTrace ends the root span of the run ctx belongs to and exports spans,
+// for when the process is about to be replaced, see runShell.
+func finishTrace(ctx context.Context, err error) {
+	if s := spanFrom(ctx); s != nil {
+		s.tr.finish(err)
+	}
+}
+
+// startSpan starts a child of the span ctx carries, returning ctx carrying
+// the new span. If ctx has no span, tracing is disabled and the returned span
+// is nil.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	s := spanFrom(ctx).child(name)
+	if s == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// spanFrom returns the span ctx carries, or nil.
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// child starts a child span.
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	c := &span{tr: s.tr, parent: s.id, name: name, start: time.Now()}
+	_, _ = rand.Read(c.id[:])
+	return c
+}
+
+// set adds an attribute to the span; value is either a string or an int. It
+// may be called from any goroutine: with -parallel, the writer sets
+// attributes of spans that workers started.
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	a := otlpAttr{Key: key}
+	switch v := value.(type) {
+	case int:
+		a.Value.IntValue = strconv.Itoa(v)
+	default:
+		a.Value.StringValue = fmt.Sprint(v)
+	}
+	s.tr.mu.Lock()
+	defer s.tr.mu.Unlock()
+	s.attrs = append(s.attrs, a)
+}
+
+// done ends the span, marking it failed if err is not nil. Ending an already
+// ended span does nothing: when the writer fails to add rows of a file, the
+// worker parsing it fails on cancellation too.
+func (s *span) done(err error) {
+	if s == nil {
+		return
+	}
+	s.tr.mu.Lock()
+	defer s.tr.mu.Unlock()
+	if !s.end.IsZero() {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	s.tr.spans = append(s.tr.spans, s)
+}
+
+// finish ends the root span and exports all ended spans, once. Export
+// failures are only logged: tracing must not fail the run.
+func (t *tracer) finish(err error) {
+	t.once.Do(func() {
+		t.root.done(err)
+		if err := t.export(); err != nil {
+			log.Printf("exporting traces to %s: %v", t.endpoint, err)
+		}
+	})
+}
+
+// export sends ended spans to the collector, over the same proxy and with
+// the same TLS settings as AWS API requests, see httpClient.
+func (t *tracer) export() error {
+	t.mu.Lock()
+	spans := make([]otlpSpan, len(t.spans))
+	for i, s := range t.spans {
+		spans[i] = otlpSpan{
+			TraceID:           hex.EncodeToString(t.traceID[:]),
+			SpanID:            hex.EncodeToString(s.id[:]),
+			Name:              s.name,
+			Kind:              1, // internal
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        s.attrs,
+		}
+		if s.parent != [8]byte{} {
+			spans[i].ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != nil {
+			spans[i].Status = &otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+	}
+	t.mu.Unlock()
+
+	var req otlpRequest
+	req.ResourceSpans = []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttr{{Key: "service.name", Value: otlpValue{StringValue: "alblogs"}}}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "alblogs"},
+			Spans: spans,
+		}},
+	}}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest(http.MethodPost, t.endpoint+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient(t.args).WithTimeout(exportTimeout).Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
+
+// Types below are the subset of the OTLP JSON encoding of trace export
+// requests used by tracer, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr  `json:"attributes,omitempty"`
+	Status            *otlpStatus `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 is error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue holds either a string or an int64, which JSON encoding of OTLP
+// represents as a decimal string.
+type otlpValue struct {
+	StringValue string `json:"stringValue,omitempty"`
+	IntValue    string `json:"intValue,omitempty"`
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/artyom/status"
+)
+
+// fakeCollector returns a server accepting OTLP/HTTP trace exports, either
+// directly or as a proxy, and a function returning spans it received.
+func fakeCollector(t *testing.T) (*httptest.Server, func() []otlpSpan) {
+	t.Helper()
+	var mu sync.Mutex
+	var spans []otlpSpan
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []otlpSpan {
+		mu.Lock()
+		defer mu.Unlock()
+		return spans
+	}
+}
+
+func TestTracingProxy(t *testing.T) {
+	// the proxy receives requests with absolute URLs of the collector
+	proxy, spans := fakeCollector(t)
+	u, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := &runArgs{OTelEndpoint: "http://collector.invalid", proxy: u}
+	err = withTracing(context.Background(), args, func(ctx context.Context) error {
+		_, s := startSpan(ctx, "list")
+		s.set("files", 3)
+		s.done(nil)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := spans()
+	if len(got) != 2 {
+		t.Fatalf("the collector behind the proxy got %d spans, want 2", len(got))
+	}
+	if got[0].Name != "list" || got[1].Name != "alblogs" {
+		t.Errorf("got spans %q and %q, want list and alblogs", got[0].Name, got[1].Name)
+	}
+}
+
+func TestTracingParallel(t *testing.T) {
+	collector, spans := fakeCollector(t)
+	dir := t.TempDir()
+	keys, counts := writeLogFiles(t, dir, 8)
+	args := &runArgs{
+		OTelEndpoint:  collector.URL,
+		Parallel:      4,
+		DecodeWorkers: 2,
+		MaxSamples:    len(keys),
+		SampleRate:    1,
+		CommitEvery:   50,
+	}
+	src := &logSource{name: "test", open: openLocalFile, stat: statLocalFile}
+	// the writer sets attributes of spans started by workers, which the
+	// race detector checks
+	err := withTracing(context.Background(), args, func(ctx context.Context) error {
+		ld, err := openDatabase(ctx, args, filepath.Join(dir, "test.db"), src)
+		if err != nil {
+			return err
+		}
+		defer ld.db.Close()
+		res, err := loadFiles(ctx, args, ld, src, keys, new(status.Line))
+		if err != nil {
+			return err
+		}
+		return res.err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string]string)
+	for _, s := range spans() {
+		if s.Name != "ingest" {
+			continue
+		}
+		var key, n string
+		for _, a := range s.Attributes {
+			switch a.Key {
+			case "key":
+				key = a.Value.StringValue
+			case "rows":
+				n = a.Value.IntValue
+			}
+		}
+		rows[key] = n
+	}
+	if len(rows) != len(keys) {
+		t.Errorf("got ingest spans for %d files, want %d", len(rows), len(keys))
+	}
+	for key, want := range counts {
+		if rows[key] != strconv.Itoa(want) {
+			t.Errorf("%s: ingest span has %q rows, want %d", key, rows[key], want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxLineSize is the maximum length of a single log entry; request URLs and
+// user agents are the only fields of unbounded size, and ALB truncates both
+// well below this limit.
+const maxLineSize = 1 << 20
+
+// ParseLine splits a single access log entry into fields, following the
+// quoting rules of splitLine, which is also used to load log files.
+func ParseLine(line []byte) ([]string, error) {
+	return splitLine(nil, string(line))
+}
+
+// splitLine splits a single log entry into fields, appending them to dst.
+//
+// Fields are separated by a single space. A field may be enclosed in double
+// quotes, in which case it may contain spaces (as in request and user_agent
+// fields, or the "- - -" placeholder of a request that could not be parsed).
+// Inside a quoted field, \" and \\ sequences stand for a literal double quote
+// and a backslash respectively; other backslashes are taken verbatim.
+//
+// Unlike encoding/csv, splitLine never treats a double quote inside a field as
+// an error, so an unusual user agent cannot shift the remaining fields.
+func splitLine(dst []string, line string) ([]string, error) {
+	line = strings.TrimSuffix(line, "\r")
+	for len(line) != 0 {
+		if line[0] != '"' {
+			i := strings.IndexByte(line, ' ')
+			if i == -1 {
+				dst = append(dst, line)
+				break
+			}
+			dst = append(dst, line[:i])
+			line = line[i+1:]
+			continue
+		}
+		line = line[1:]
+		var field string
+		if i := strings.IndexAny(line, `"\`); i != -1 && line[i] == '"' {
+			// fast path: no escape sequences
+			field, line = line[:i], line[i+1:]
+		} else {
+			var b strings.Builder
+			for {
+				i := strings.IndexAny(line, `"\`)
+				if i == -1 {
+					return dst, errUnterminatedQuote
+				}
+				b.WriteString(line[:i])
+				if line[i] == '"' {
+					line = line[i+1:]
+					break
+				}
+				if i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+					b.WriteByte(line[i+1])
+					line = line[i+2:]
+					continue
+				}
+				b.WriteByte('\\')
+				line = line[i+1:]
+			}
+			field = b.String()
+		}
+		if len(line) != 0 && line[0] != ' ' {
+			// text right after the closing quote belongs to the same field
+			i := strings.IndexByte(line, ' ')
+			if i == -1 {
+				i = len(line)
+			}
+			field += line[:i]
+			line = line[i:]
+		}
+		dst = append(dst, field)
+		if len(line) == 0 {
+			break
+		}
+		line = line[1:]
+	}
+	return dst, nil
+}
+
+// minLogFields is the number of leading fields (type through user_agent)
+// present in every version of the access log format.
+const minLogFields = 14
+
+// layoutNote describes how log entries having n fields differ from the known
+// list of fields cols, or returns an empty string if they match.
+//
+// AWS only ever appends new fields to the end of log entries. Entries of load
+// balancers that don't yet emit some of the recent fields (such as
+// classification and classification_reason) are shorter, and those fields are
+// stored as NULL. Entries longer than the known list carry fields that were
+// introduced after the list was last updated; as AWS advises, those are
+// ignored.
+func layoutNote(n int, cols []string) string {
+	switch {
+	case n < len(cols):
+		return fmt.Sprintf("log entries have %d fields instead of %d, storing %s as NULL",
+			n, len(cols), strings.Join(cols[n:], ", "))
+	case n > len(cols):
+		return fmt.Sprintf("log entries have %d fields instead of %d, ignoring %d unknown trailing fields",
+			n, len(cols), n-len(cols))
+	}
+	return ""
+}
+
+var errUnterminatedQuote = errors.New("quoted field is not terminated")
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Log entries in the formats documented at
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#access-log-entry-examples
+const (
+	lineHTTP = `http 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.000 0.001 0.000 200 200 34 366 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.46.0" - - arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337262-36d228ad5d99923122bbe354" "-" "-" 0 2018-07-02T22:22:48.364000Z "forward" "-" "-" "10.0.0.1:80" "200" "-" "-" TID_1234abcd5678ef90`
+
+	lineQuotedAgent = `https 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.086 0.048 0.037 200 200 0 57 "GET https://www.example.com:443/ HTTP/1.1" "Mozilla/5.0 (X11; Linux) \"Quoted\" Agent\\1.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337281-1d84f3d73c47ec4e58577259" "www.example.com" "arn:aws:acm:us-east-2:123456789012:certificate/12345678-1234-1234-1234-123456789012" 1 2018-07-02T22:22:48.364000Z "authenticate,forward" "-" "-" "10.0.0.1:80" "200" "-" "-" TID_1234abcd5678ef90`
+
+	lineIPv6 = `https 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 [2001:db8::8a2e:370:7334]:52689 [2001:db8::1]:443 0.000 0.002 0.000 200 200 5 257 "GET https://[2001:db8::1]:443/ HTTP/1.1" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.3 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337327-72bd00b0343d75b906739c42" "-" "-" 1 2018-07-02T22:22:48.364000Z "forward" "-" "-" "[2001:db8::1]:443" "200" "-" "-" TID_1234abcd5678ef90`
+
+	lineGRPC = `grpcs 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 10.0.1.252:48160 10.0.0.66:50051 0.000 0.002 0.000 200 200 5 257 "POST https://10.0.2.105:443/helloworld.Greeter/SayHello HTTP/2.0" "grpc-go/1.20.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337327-72bd00b0343d75b906739c42" "-" "-" 1 2018-07-02T22:22:48.364000Z "forward" "-" "-" "10.0.0.66:50051" "200" "-" "-" TID_1234abcd5678ef90`
+
+	lineWAF = `https 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 - 0.000 -1 -1 403 - 34 366 "GET https://www.example.com:443/admin HTTP/1.1" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 - "Root=1-58337262-36d228ad5d99923122bbe354" "www.example.com" "arn:aws:acm:us-east-2:123456789012:certificate/12345678-1234-1234-1234-123456789012" 0 2018-07-02T22:22:48.364000Z "waf" "-" "-" "-" "-" "Ambiguous" "UndefinedContentLengthSemantics" TID_1234abcd5678ef90`
+)
+
+// gzipLines returns the gzip-compressed log file with the lines.
+func gzipLines(t testing.TB, lines ...string) io.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, s := range lines {
+		io.WriteString(zw, s+"\n")
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// testLoader returns the loader of the embedded log fields, with derived
+// columns, that doesn't need a database for decode.
+func testLoader() *loader {
+	cols := logFields(&runArgs{})
+	return &loader{cols: cols, derived: derivedColumns(cols), sampleRate: 1}
+}
+
+// decodeRows decodes the lines into rows keyed by column names.
+func decodeRows(t testing.TB, l *loader, lines ...string) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	err := l.decode(gzipLines(t, lines...), "test.log.gz", 0, nil, func(row []any, _ int) error {
+		m := make(map[string]any, len(row))
+		for i, v := range row {
+			if i < len(l.cols) {
+				m[l.cols[i]] = v
+			} else {
+				m[l.derived[i-len(l.cols)].name] = v
+			}
+		}
+		out = append(out, m)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestDecodeFixtures(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		line string
+		want map[string]any
+	}{
+		{"http", lineHTTP, map[string]any{
+			"type":               "http",
+			"client_port":        "192.168.131.39:2817",
+			"elb_status_code":    uint64(200),
+			"request":            "GET http://www.example.com:80/ HTTP/1.1",
+			"user_agent":         "curl/7.46.0",
+			"ssl_protocol":       "-",
+			"conn_trace_id":      "TID_1234abcd5678ef90",
+			"protocol_version":   1.1,
+			"is_grpc":            0,
+			"action_forward":     1,
+			"action_waf":         0,
+			"target_status_code": uint64(200),
+		}},
+		{"quoted user agent", lineQuotedAgent, map[string]any{
+			"user_agent":          `Mozilla/5.0 (X11; Linux) "Quoted" Agent\1.0`,
+			"ssl_cipher":          "ECDHE-RSA-AES128-GCM-SHA256",
+			"domain_name":         "www.example.com",
+			"action_authenticate": 1,
+			"action_forward":      1,
+		}},
+		{"ipv6 client", lineIPv6, map[string]any{
+			"client_port":      "[2001:db8::8a2e:370:7334]:52689",
+			"target_port":      "[2001:db8::1]:443",
+			"request":          "GET https://[2001:db8::1]:443/ HTTP/1.1",
+			"target_port_list": "[2001:db8::1]:443",
+			"ssl_protocol":     "TLSv1.3",
+		}},
+		{"grpc", lineGRPC, map[string]any{
+			"type":             "grpcs",
+			"user_agent":       "grpc-go/1.20.0",
+			"protocol_version": 2.0,
+			"is_grpc":          1,
+		}},
+		{"waf and classification", lineWAF, map[string]any{
+			"target_port":             "-",
+			"target_processing_time":  "-1",
+			"elb_status_code":         uint64(403),
+			"target_status_code":      "-",
+			"actions_executed":        "waf",
+			"classification":          "Ambiguous",
+			"classification_reason":   "UndefinedContentLengthSemantics",
+			"target_status_code_list": "-",
+			"action_waf":              1,
+			"action_forward":          0,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rows := decodeRows(t, testLoader(), tc.line)
+			if len(rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(rows))
+			}
+			for col, want := range tc.want {
+				if got := rows[0][col]; got != want {
+					t.Errorf("%s: got %#v, want %#v", col, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitLineFixtures(t *testing.T) {
+	cols := logFields(&runArgs{})
+	for _, line := range []string{lineHTTP, lineQuotedAgent, lineIPv6, lineGRPC, lineWAF} {
+		fields, err := splitLine(nil, line)
+		if err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if len(fields) != len(cols) {
+			t.Errorf("%q: got %d fields, want %d", line, len(fields), len(cols))
+		}
+		if i := slices.Index(cols, "conn_trace_id"); !strings.HasPrefix(fields[i], "TID_") {
+			t.Errorf("%q: fields are misaligned, conn_trace_id is %q", line, fields[i])
+		}
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+		err  error
+	}{
+		{line: `a b c`, want: []string{"a", "b", "c"}},
+		{line: `a "b c" d`, want: []string{"a", "b c", "d"}},
+		{line: `a "" b`, want: []string{"a", "", "b"}},
+		{line: `"- - -" x`, want: []string{"- - -", "x"}},
+		{line: `"say \"hi\" now" x`, want: []string{`say "hi" now`, "x"}},
+		{line: `"a\\b" x`, want: []string{`a\b`, "x"}},
+		{line: `"C:\path\to" x`, want: []string{`C:\path\to`, "x"}},
+		{line: `"ends with \\" x`, want: []string{`ends with \`, "x"}},
+		{line: `"a"b c`, want: []string{"ab", "c"}},
+		{line: `a"b c`, want: []string{`a"b`, "c"}},
+		{line: "a b\r", want: []string{"a", "b"}},
+		{line: `"\"" "\\"`, want: []string{`"`, `\`}},
+		{line: `a "b`, err: errUnterminatedQuote},
+		{line: `"a\"`, err: errUnterminatedQuote},
+	} {
+		got, err := splitLine(nil, tc.line)
+		if err != tc.err {
+			t.Errorf("%q: got error %v, want %v", tc.line, err, tc.err)
+			continue
+		}
+		if tc.err == nil && !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestLayoutNote(t *testing.T) {
+	cols := []string{"a", "b", "c"}
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{
+		{3, ""},
+		{2, "log entries have 2 fields instead of 3, storing c as NULL"},
+		{5, "log entries have 5 fields instead of 3, ignoring 2 unknown trailing fields"},
+	} {
+		if got := layoutNote(tc.n, cols); got != tc.want {
+			t.Errorf("layoutNote(%d): got %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeLayouts(t *testing.T) {
+	// entries written before classification fields were introduced
+	older := lineHTTP[:strings.Index(lineHTTP, ` "-" "-" TID_`)]
+	// entries with fields introduced after the embedded list
+	newer := lineHTTP + ` "new" value`
+
+	rows := decodeRows(t, testLoader(), older, older)
+	if len(rows) != 2 {
+		t.Fatalf("older layout: got %d rows, want 2", len(rows))
+	}
+	for _, col := range []string{"classification", "classification_reason", "conn_trace_id"} {
+		if v := rows[0][col]; v != nil {
+			t.Errorf("older layout: %s is %#v, want NULL", col, v)
+		}
+	}
+	if v := rows[0]["target_status_code_list"]; v != uint64(200) {
+		t.Errorf("older layout: target_status_code_list is %#v, want 200", v)
+	}
+
+	rows = decodeRows(t, testLoader(), newer)
+	if len(rows) != 1 {
+		t.Fatalf("newer layout: got %d rows, want 1", len(rows))
+	}
+	if v := rows[0]["conn_trace_id"]; v != "TID_1234abcd5678ef90" {
+		t.Errorf("newer layout: conn_trace_id is %#v", v)
+	}
+	if v := rows[0]["protocol_version"]; v != 1.1 {
+		t.Errorf("newer layout: derived columns are misaligned, protocol_version is %#v", v)
+	}
+
+	// the layout is taken from the first entry of each file
+	l := testLoader()
+	err := l.decode(gzipLines(t, lineHTTP, older), "mixed.log.gz", 0, nil, func([]any, int) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 2: wrong number of fields") {
+		t.Errorf("mixed layouts: got error %v", err)
+	}
+	err = l.decode(gzipLines(t, "http 2018-07-02T22:23:00.186641Z too short"), "short.log.gz", 0, nil, func([]any, int) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "want at least") {
+		t.Errorf("short entry: got error %v", err)
+	}
+}
+
+func TestDecodeRowRetention(t *testing.T) {
+	// rows are kept the way the parallel writer keeps them: the row slice
+	// is reused, so it's shallow-copied, values in it must stay intact;
+	// the file is larger than the scanner buffer, so it's refilled
+	fixtures := []string{lineHTTP, lineQuotedAgent, lineIPv6, lineGRPC, lineWAF}
+	var lines []string
+	for len(lines) < 500 {
+		lines = append(lines, fixtures...)
+	}
+	l := testLoader()
+	var rows [][]any
+	err := l.decode(gzipLines(t, lines...), "test.log.gz", 0, nil, func(row []any, _ int) error {
+		rows = append(rows, slices.Clone(row))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(lines) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(lines))
+	}
+	var want []map[string]any
+	for _, line := range fixtures {
+		want = append(want, decodeRows(t, testLoader(), line)[0])
+	}
+	for i, row := range rows {
+		want := want[i%len(fixtures)]
+		for j, v := range row {
+			var name string
+			if j < len(l.cols) {
+				name = l.cols[j]
+			} else {
+				name = l.derived[j-len(l.cols)].name
+			}
+			if v != want[name] {
+				t.Fatalf("row %d, %s: got %#v after later lines were read, want %#v", i+1, name, v, want[name])
+			}
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	fixtures := []string{lineHTTP, lineQuotedAgent, lineIPv6, lineGRPC, lineWAF}
+	var lines []string
+	var size int64
+	for len(lines) < 10000 {
+		for _, s := range fixtures {
+			lines = append(lines, s)
+			size += int64(len(s)) + 1
+		}
+	}
+	data, err := io.ReadAll(gzipLines(b, lines...))
+	if err != nil {
+		b.Fatal(err)
+	}
+	l := testLoader()
+	emit := func([]any, int) error { return nil }
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if err := l.decode(bytes.NewReader(data), "bench.log.gz", 0, nil, emit); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
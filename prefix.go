@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// prefixTemplate is the -prefix value: the S3 key prefix of log files with
+// date placeholders, for buckets where logs are moved from the standard
+// AWSLogs/account/elasticloadbalancing/region/yyyy/mm/dd layout.
+//
+// Placeholders are %Y (year), %m (month), %d (day), %H (hour), all zero-padded
+// and in UTC, %% for a literal percent sign, and {account} and {region} for
+// the load balancer account id and region.
+type prefixTemplate string
+
+// validate reports an error if the template has unknown placeholders.
+func (p prefixTemplate) validate() error {
+	s := string(p)
+	for i := strings.IndexByte(s, '%'); i != -1; i = strings.IndexByte(s, '%') {
+		if i+1 == len(s) || !strings.ContainsRune("YmdH%", rune(s[i+1])) {
+			return fmt.Errorf("-prefix %q: unknown placeholder at position %d, supported are %%Y, %%m, %%d, %%H, and %%%%", string(p), len(p)-len(s)+i)
+		}
+		s = s[i+2:]
+	}
+	return nil
+}
+
+// expand returns the prefix for the time t.
+func (p prefixTemplate) expand(t time.Time, account, region string) string {
+	t = t.UTC()
+	r := strings.NewReplacer(
+		"%%", "%",
+		"%Y", t.Format("2006"),
+		"%m", t.Format("01"),
+		"%d", t.Format("02"),
+		"%H", t.Format("15"),
+		"{account}", account,
+		"{region}", region,
+	)
+	return r.Replace(string(p))
+}
+
+// static returns the part of the prefix before its first date placeholder,
+// which all log files are under whatever their time.
+func (p prefixTemplate) static(account, region string) string {
+	s := string(p)
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		if s[i+1] != '%' {
+			s = s[:i]
+			break
+		}
+		i++ // skip the escaped percent sign
+	}
+	return prefixTemplate(s).expand(time.Time{}, account, region)
+}
+
+// prefixes returns prefixes of all days, or hours if the template has the
+// %H placeholder, the half-open [from, to) interval spans. Like
+// windowPrefixes, it only narrows down listing: files are still selected by
+// their delivery time.
+func (p prefixTemplate) prefixes(from, to time.Time, account, region string) []string {
+	step := 24 * time.Hour
+	if strings.Contains(strings.ReplaceAll(string(p), "%%", ""), "%H") {
+		step = time.Hour
+	}
+	var out []string
+	for t := from.UTC().Truncate(step); t.Before(to); t = t.Add(step) {
+		// without date placeholders, all prefixes are the same
+		if s := p.expand(t, account, region); !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// presets are named queries for common investigations. Log fields are
+// referenced as {field_name} and replaced with the actual column names, see
+// expandColumns.
+var presets = map[string]string{
+	// requests that took the longest for targets to process
+	"slow-requests": `SELECT {time}, {client_port}, {target_port}, {elb_status_code},
+		{target_processing_time}, {request}
+		FROM logs WHERE {target_processing_time} >= 0
+		ORDER BY {target_processing_time} DESC LIMIT 20`,
+	// most frequent requests rejected with 4xx status codes
+	"top-4xx": `SELECT {elb_status_code}, {request}, count(*) AS requests
+		FROM logs WHERE {elb_status_code} BETWEEN 400 AND 499
+		GROUP BY 1, 2 ORDER BY 3 DESC LIMIT 20`,
+	// clients making the most requests, with their share of errors
+	"client-breakdown": `SELECT rtrim(rtrim({client_port}, '0123456789'), ':') AS client,
+		count(*) AS requests,
+		round(100.0*count(*)/(SELECT count(*) FROM logs), 1) AS percent,
+		sum({elb_status_code} BETWEEN 400 AND 499) AS "4xx",
+		sum({elb_status_code} BETWEEN 500 AND 599) AS "5xx"
+		FROM logs GROUP BY 1 ORDER BY 2 DESC LIMIT 20`,
+	// responses whose status code the load balancer changed from the one
+	// the target returned, or made up without a target response, logged as
+	// "-", such as 502 and 504: these are gateway failures rather than
+	// application errors
+	"status-mismatch": `SELECT {elb_status_code}, {target_status_code}, {target_port}, count(*) AS requests
+		FROM logs WHERE typeof({elb_status_code}) = 'integer'
+		AND (typeof({target_status_code}) != 'integer' OR {elb_status_code} != {target_status_code})
+		GROUP BY 1, 2, 3 ORDER BY 4 DESC LIMIT 20`,
+	// TLS protocols and ciphers of encrypted requests, to find clients
+	// left behind by deprecation of old ones
+	"tls-versions": `SELECT {ssl_protocol}, {ssl_cipher}, count(*) AS requests,
+		round(100.0*count(*)/(SELECT count(*) FROM logs WHERE {ssl_protocol} != '-'), 1) AS percent,
+		count(DISTINCT rtrim(rtrim({client_port}, '0123456789'), ':')) AS clients
+		FROM logs WHERE {ssl_protocol} != '-'
+		GROUP BY 1, 2 ORDER BY 1, 3 DESC`,
+}
+
+// presetNames returns sorted names of presets.
+func presetNames() []string {
+	var out []string
+	for name := range presets {
+		out = append(out, name)
+	}
+	slices.Sort(out)
+	return out
+}
+
+var placeholderRe = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// expandColumns replaces {field_name} placeholders in query with quoted names
+// of the columns holding those log fields.
+func expandColumns(ctx context.Context, db *sql.DB, query string) (string, error) {
+	var err error
+	out := placeholderRe.ReplaceAllStringFunc(query, func(s string) string {
+		if err != nil {
+			return s
+		}
+		var col string
+		col, err = columnName(ctx, db, strings.Trim(s, "{}"))
+		return `"` + col + `"`
+	})
+	return out, err
+}
+
+// runPreset runs the named preset query and writes its results to w in the
+// given format: table, csv, or json. Floating-point numbers are written with
+// the precision, see formatFloat.
+func runPreset(ctx context.Context, w io.Writer, db *sql.DB, name, format string, precision int) error {
+	query, ok := presets[name]
+	if !ok {
+		return fmt.Errorf("unknown preset %q, known presets are: %s", name, strings.Join(presetNames(), ", "))
+	}
+	query, err := expandColumns(ctx, db, query)
+	if err != nil {
+		return err
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	return writeRows(w, rows, format, precision)
+}
+
+// writeRows writes all rows to w in the given format: table, csv, or json.
+func writeRows(w io.Writer, rows *sql.Rows, format string, precision int) error {
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(cols, "\t"))
+		record := make([]string, len(cols))
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				return err
+			}
+			for i, v := range vals {
+				record[i] = formatValue(v, precision)
+			}
+			fmt.Fprintln(tw, strings.Join(record, "\t"))
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(cols); err != nil {
+			return err
+		}
+		record := make([]string, len(cols))
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				return err
+			}
+			for i, v := range vals {
+				record[i] = formatValue(v, precision)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		var out []map[string]any
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				return err
+			}
+			m := make(map[string]any, len(cols))
+			for i, v := range vals {
+				switch x := v.(type) {
+				case []byte:
+					v = string(x)
+				case float64:
+					if precision >= 0 {
+						v = json.Number(formatFloat(x, precision))
+					}
+				}
+				m[cols[i]] = v
+			}
+			out = append(out, m)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if out == nil {
+			out = []map[string]any{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	return fmt.Errorf("unsupported output format %q", format)
+}
+
+// formatValue returns text representation of a value scanned from the
+// database; NULL is represented by an empty string.
+func formatValue(v any, precision int) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case float64:
+		return formatFloat(v, precision)
+	}
+	return fmt.Sprint(v)
+}
+
+// formatFloat formats f in fixed-point notation with precision decimal
+// digits, or, if precision is negative, in the shortest form representing f
+// exactly, which has an exponent for very small or large numbers.
+func formatFloat(f float64, precision int) string {
+	if precision < 0 {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', precision, 64)
+}
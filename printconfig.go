@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// effectiveConfig is what -print-config prints: settings resolved from
+// flags, saved flags, environment, and load balancer metadata.
+type effectiveConfig struct {
+	Profile   string         `json:"profile,omitempty"`
+	Directory string         `json:"directory,omitempty"`
+	Sources   []sourceConfig `json:"sources,omitempty"`
+	// window is only set for S3 sources, with -dir all files match
+	WindowStart *time.Time `json:"window_start,omitempty"`
+	WindowEnd   *time.Time `json:"window_end,omitempty"`
+	// DeliveryLag is how much longer after the window end log files are
+	// still picked up, see -delivery-lag
+	DeliveryLag string `json:"delivery_lag,omitempty"`
+	MaxFiles    int    `json:"max_files"`
+	Database    string `json:"database"`
+}
+
+type sourceConfig struct {
+	LoadBalancer string   `json:"load_balancer"`
+	Region       string   `json:"region"`
+	Bucket       string   `json:"bucket"`
+	BucketOwner  string   `json:"bucket_owner,omitempty"`
+	Prefixes     []string `json:"prefixes"`
+}
+
+// printConfig writes effective settings of the run loading logs from src as
+// JSON.
+func printConfig(w io.Writer, args *runArgs, src *logSource) error {
+	cfg := effectiveConfig{
+		MaxFiles: args.MaxSamples,
+		Database: databaseName(args, src),
+	}
+	if args.Dir != "" {
+		cfg.Directory = args.Dir
+	} else {
+		start, end := args.window.From.UTC(), args.window.To.UTC()
+		cfg.WindowStart, cfg.WindowEnd = &start, &end
+		if args.DeliveryLag != 0 {
+			cfg.DeliveryLag = args.DeliveryLag.String()
+		}
+		cfg.Profile = args.Profile
+		members := src.members
+		if members == nil {
+			members = []*logSource{src}
+		}
+		for _, s := range members {
+			cfg.Sources = append(cfg.Sources, sourceConfig{
+				LoadBalancer: s.name,
+				Region:       s.region,
+				Bucket:       s.bucket,
+				BucketOwner:  args.BucketOwner,
+				Prefixes:     s.prefixes(args.window.From),
+			})
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cfg)
+}
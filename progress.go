@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artyom/status"
+	"golang.org/x/term"
+)
+
+// progressBar reports loading progress with -progress-bar: the number of
+// loaded files out of the total, bytes downloaded, and the estimated time
+// left. On a terminal it draws a bar sized to its width on the status line,
+// otherwise it logs a line per loaded file. It is safe for concurrent use.
+type progressBar struct {
+	line  *status.Line
+	tty   bool
+	total int
+	start time.Time
+
+	mu       sync.Mutex
+	done     int
+	bytes    int64
+	lastDraw time.Time
+}
+
+func newProgressBar(line *status.Line, total int) *progressBar {
+	return &progressBar{
+		line:  line,
+		tty:   term.IsTerminal(int(os.Stderr.Fd())),
+		total: total,
+		start: time.Now(),
+	}
+}
+
+// wrap returns openFunc counting bytes read from files opened by open.
+func (p *progressBar) wrap(open openFunc) openFunc {
+	return func(ctx context.Context, key string) (io.ReadCloser, error) {
+		rc, err := open(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return &progressReader{ReadCloser: rc, p: p}, nil
+	}
+}
+
+// fileDone accounts for a loaded file.
+func (p *progressBar) fileDone(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if !p.tty {
+		log.Printf("Loaded %s (%d of %d, %s downloaded)", path.Base(key), p.done, p.total, formatSize(p.bytes))
+		return
+	}
+	p.draw()
+}
+
+func (p *progressBar) addBytes(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += int64(n)
+	// reads are frequent, so redraw no more than a few times a second
+	if p.tty && time.Since(p.lastDraw) >= 200*time.Millisecond {
+		p.draw()
+	}
+}
+
+// draw prints the bar on the status line; p.mu must be held.
+func (p *progressBar) draw() {
+	p.lastDraw = time.Now()
+	eta := "ETA unknown"
+	if p.done != 0 && p.done < p.total {
+		elapsed := time.Since(p.start)
+		left := elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)
+		eta = "ETA " + left.Round(time.Second).String()
+	} else if p.done >= p.total {
+		eta = "done"
+	}
+	text := fmt.Sprintf(" %d/%d files, %s, %s", p.done, p.total, formatSize(p.bytes), eta)
+	width := 80
+	if w, _, err := term.GetSize(int(os.Stderr.Fd())); err == nil && w > 0 {
+		width = w
+	}
+	// the status line must not wrap, leave room for brackets and text
+	barWidth := min(width-len(text)-3, 50)
+	if barWidth < 5 {
+		p.line.Print(strings.TrimSpace(text))
+		return
+	}
+	filled := barWidth * min(p.done, p.total) / max(p.total, 1)
+	p.line.Print("[" + strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled) + "]" + text)
+}
+
+// progressReader reports bytes read to the progress bar.
+type progressReader struct {
+	io.ReadCloser
+	p *progressBar
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.p.addBytes(n)
+	return n, err
+}
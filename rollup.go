@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// rollupQuery computes per-period aggregates of log entries; ? stands for
+// the period length in seconds. Processing times are in the same units as in
+// the logs table.
+const rollupQuery = `CREATE TABLE rollups AS SELECT
+	datetime(CAST(strftime('%s', substr({time}, 1, 19)) AS INTEGER) / ? * ?, 'unixepoch') AS period,
+	count(*) AS requests,
+	sum({elb_status_code} BETWEEN 200 AND 299) AS "2xx",
+	sum({elb_status_code} BETWEEN 300 AND 399) AS "3xx",
+	sum({elb_status_code} BETWEEN 400 AND 499) AS "4xx",
+	sum({elb_status_code} BETWEEN 500 AND 599) AS "5xx",
+	avg(CASE WHEN {target_processing_time} >= 0 THEN {target_processing_time} END) AS avg_target_time,
+	max({target_processing_time}) AS max_target_time,
+	sum({received_bytes}) AS received_bytes,
+	sum({sent_bytes}) AS sent_bytes
+	FROM logs GROUP BY 1 ORDER BY 1`
+
+// writeRollups (re)creates the rollups table holding aggregates of all log
+// entries over periods of the given length, with the period start time
+// (in UTC) in the period column.
+func writeRollups(ctx context.Context, db *sql.DB, period time.Duration) error {
+	query, err := expandColumns(ctx, db, rollupQuery)
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DROP TABLE IF EXISTS rollups`); err != nil {
+		return err
+	}
+	secs := int64(period / time.Second)
+	if _, err := tx.ExecContext(ctx, query, secs, secs); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO run_meta VALUES('rollup_period', ?)`,
+		strconv.FormatInt(secs, 10)+"s"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
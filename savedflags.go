@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// persistentFlags are flags remembered per load balancer and used as
+// defaults on later runs for it: those describing the environment rather
+// than a particular investigation. Credentials are never saved.
+var persistentFlags = []string{
+	"p", "timezone", "utc", "bucket-owner", "http-proxy",
+	"raw-columns", "time-ms", "exclude-health-checks", "parallel", "color",
+}
+
+func savedFlagsFile() string { return filepath.Join(cacheDir(), "alblogs-flags.json") }
+
+// applySavedFlags sets flags saved for the load balancer identified by
+// target, unless they are set on the command line. It returns the function
+// saving persistent flags set on the command line for the load balancer,
+// which is nil if there are none: it's only called once the flags are
+// validated, so that a typo is not remembered. With forget, saved flags are
+// removed instead.
+func applySavedFlags(target string, forget bool) (save func() error, err error) {
+	saved := make(map[string]map[string]string)
+	if b, err := os.ReadFile(savedFlagsFile()); err == nil {
+		// a corrupt file is overwritten below
+		_ = json.Unmarshal(b, &saved)
+	}
+	if forget {
+		if _, ok := saved[target]; !ok {
+			log.Printf("No flags are saved for %s", target)
+			return nil, nil
+		}
+		delete(saved, target)
+		log.Printf("Forgot saved flags for %s", target)
+		return nil, writeSavedFlags(saved)
+	}
+	explicit := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if slices.Contains(persistentFlags, f.Name) {
+			explicit[f.Name] = f.Value.String()
+		}
+	})
+	var used []string
+	for name, value := range saved[target] {
+		if _, ok := explicit[name]; ok || !slices.Contains(persistentFlags, name) {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return nil, fmt.Errorf("saved flag -%s for %s: %w, use -forget to clear saved flags", name, target, err)
+		}
+		used = append(used, fmt.Sprintf("-%s=%s", name, value))
+	}
+	if len(used) != 0 {
+		sort.Strings(used)
+		log.Printf("Using saved flags for %s: %s", target, strings.Join(used, " "))
+	}
+	if len(explicit) == 0 {
+		return nil, nil
+	}
+	return func() error {
+		if saved[target] == nil {
+			saved[target] = make(map[string]string)
+		}
+		for name, value := range explicit {
+			saved[target][name] = value
+		}
+		return writeSavedFlags(saved)
+	}, nil
+}
+
+func writeSavedFlags(saved map[string]map[string]string) error {
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(savedFlagsFile()), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(savedFlagsFile(), b, 0666)
+}
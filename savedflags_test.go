@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+// setCommandLine replaces the command line flag set with one having some of
+// the persistent flags, parsed from args.
+func setCommandLine(t *testing.T, args ...string) (profile *string, parallel *int) {
+	t.Helper()
+	orig := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = orig })
+	flag.CommandLine = flag.NewFlagSet("alblogs", flag.ContinueOnError)
+	profile = flag.String("p", "", "")
+	parallel = flag.Int("parallel", 1, "")
+	flag.Bool("dry-run", false, "")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return profile, parallel
+}
+
+func TestSavedFlags(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	const target = "my-lb@us-west-2"
+
+	setCommandLine(t, "-p", "prod", "-parallel", "4", "-dry-run")
+	save, err := applySavedFlags(target, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if save == nil {
+		t.Fatal("no function to save explicitly set flags")
+	}
+	// nothing is saved until flags are validated
+	if _, err := os.Stat(savedFlagsFile()); !os.IsNotExist(err) {
+		t.Fatalf("flags file exists before flags are saved: %v", err)
+	}
+	if err := save(); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, parallel := setCommandLine(t, "-parallel", "2")
+	if save, err = applySavedFlags(target, false); err != nil {
+		t.Fatal(err)
+	}
+	if *profile != "prod" {
+		t.Errorf("got -p %q, want the saved one", *profile)
+	}
+	if *parallel != 2 {
+		t.Errorf("got -parallel %d, want the one from the command line", *parallel)
+	}
+	if err := save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// flags are saved per load balancer
+	profile, _ = setCommandLine(t)
+	if save, err = applySavedFlags("other-lb", false); err != nil {
+		t.Fatal(err)
+	}
+	if save != nil {
+		t.Error("got a function to save flags, none are set on the command line")
+	}
+	if *profile != "" {
+		t.Errorf("got -p %q saved for another load balancer", *profile)
+	}
+
+	for range 2 {
+		if _, err := applySavedFlags(target, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	profile, parallel = setCommandLine(t)
+	if _, err := applySavedFlags(target, false); err != nil {
+		t.Fatal(err)
+	}
+	if *profile != "" || *parallel != 1 {
+		t.Errorf("got -p %q -parallel %d after -forget", *profile, *parallel)
+	}
+}
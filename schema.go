@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// schemaVersion is the version of the database layout, stored as the
+// database user_version. Databases created by older versions of the program
+// have user_version 0.
+const schemaVersion = 1
+
+// columnDef describes a single column of the logs table.
+type columnDef struct {
+	name    string
+	sqlType string // empty if column has no declared type
+}
+
+func (c columnDef) String() string {
+	if c.sqlType == "" {
+		return "'" + c.name + "'"
+	}
+	return "'" + c.name + "' " + c.sqlType
+}
+
+// schemaColumns returns columns of the logs table: one per log field in cols,
+// followed by derived columns. Columns for log fields listed in aliases are
+// named after their aliases. If timeMS is true, processing times are stored as
+// integer milliseconds.
+func schemaColumns(cols []string, aliases map[string]string, derived []derivedColumn, timeMS bool) []columnDef {
+	out := make([]columnDef, 0, len(cols)+len(derived))
+	for _, col := range cols {
+		var colType string
+		switch col {
+		case "elb_status_code", "target_status_code",
+			"received_bytes", "sent_bytes",
+			"matched_rule_priority":
+			colType = "INTEGER"
+		case "request_processing_time", "target_processing_time", "response_processing_time":
+			colType = "REAL"
+			if timeMS {
+				colType = "INTEGER"
+			}
+		}
+		name := col
+		if alias, ok := aliases[col]; ok {
+			name = alias
+		}
+		out = append(out, columnDef{name: name, sqlType: colType})
+	}
+	for _, dc := range derived {
+		out = append(out, columnDef{name: dc.name, sqlType: dc.sqlType})
+	}
+	return out
+}
+
+// migrateSchema brings an existing logs table with columns existing up to the
+// layout of want, adding missing columns: fields AWS added to log entries, or
+// derived columns introduced after the database was created. Since rows are
+// inserted with explicitly named columns, the order of columns doesn't matter,
+// and existing rows get NULL in the added columns. Databases created by a
+// newer version of the program are refused.
+func migrateSchema(ctx context.Context, db *sql.DB, existing []string, want []columnDef) error {
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("created by a newer version of the program (schema version %d, this program supports up to %d)",
+			version, schemaVersion)
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+	have := make(map[string]struct{}, len(existing))
+	for _, name := range existing {
+		have[fieldName(name)] = struct{}{}
+	}
+	for _, c := range want {
+		if _, ok := have[fieldName(c.name)]; ok {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `ALTER TABLE logs ADD COLUMN `+c.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// intersectColumns returns those of columns defs the logs table with columns
+// existing already has, and which of defs these are. If the table has all of
+// them, keep is nil. Names of the other columns are returned as skipped.
+func intersectColumns(existing []string, defs []columnDef) (out []columnDef, keep []bool, skipped []string) {
+	have := make(map[string]struct{}, len(existing))
+	for _, name := range existing {
+		have[fieldName(name)] = struct{}{}
+	}
+	keep = make([]bool, len(defs))
+	for i, c := range defs {
+		if _, keep[i] = have[fieldName(c.name)]; keep[i] {
+			out = append(out, c)
+		} else {
+			skipped = append(skipped, c.name)
+		}
+	}
+	if len(skipped) == 0 {
+		keep = nil
+	}
+	return out, keep, skipped
+}
+
+// insertColumns returns names of the logs table columns to insert values of
+// columns defs into, as named in the existing table: it may have been created
+// with or without -raw-columns.
+func insertColumns(ctx context.Context, db *sql.DB, defs []columnDef) ([]string, error) {
+	existing, err := tableColumnList(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	byField := make(map[string]string, len(existing))
+	for _, name := range existing {
+		byField[fieldName(name)] = name
+	}
+	out := make([]string, len(defs))
+	for i, c := range defs {
+		name, ok := byField[fieldName(c.name)]
+		if !ok {
+			return nil, fmt.Errorf("logs table has no column for %q", c.name)
+		}
+		out[i] = name
+	}
+	return out, nil
+}
+
+// numericColumns reports which of columns defs have numeric types.
+func numericColumns(defs []columnDef) []bool {
+	out := make([]bool, len(defs))
+	for i, c := range defs {
+		out[i] = c.sqlType == "INTEGER" || c.sqlType == "REAL"
+	}
+	return out
+}
+
+// fieldName returns the log field name for a column, which may be named after
+// the field alias.
+func fieldName(col string) string {
+	for field, alias := range columnAliases {
+		if alias == col {
+			return field
+		}
+	}
+	return col
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// knownFields is the list of log fields the embedded fields.txt must start
+// with. AWS only ever appends fields to log entries, so existing databases
+// and saved queries depend on these names and their order; update-fields
+// refuses to drop or reorder them, and so does this test.
+var knownFields = []string{
+	"type",
+	"time",
+	"elb",
+	"client_port",
+	"target_port",
+	"request_processing_time",
+	"target_processing_time",
+	"response_processing_time",
+	"elb_status_code",
+	"target_status_code",
+	"received_bytes",
+	"sent_bytes",
+	"request",
+	"user_agent",
+	"ssl_cipher",
+	"ssl_protocol",
+	"target_group_arn",
+	"trace_id",
+	"domain_name",
+	"chosen_cert_arn",
+	"matched_rule_priority",
+	"request_creation_time",
+	"actions_executed",
+	"redirect_url",
+	"error_reason",
+	"target_port_list",
+	"target_status_code_list",
+	"classification",
+	"classification_reason",
+	"conn_trace_id",
+}
+
+func TestFieldsList(t *testing.T) {
+	fields := logFields(&runArgs{})
+	if len(fields) < len(knownFields) || !slices.Equal(fields[:len(knownFields)], knownFields) {
+		t.Fatalf("embedded fields.txt doesn't start with the known fields:\ngot  %q\nwant %q", fields, knownFields)
+	}
+	if slices.Index(fields, "user_agent") != minLogFields-1 {
+		t.Errorf("user_agent is not field #%d", minLogFields)
+	}
+}
+
+func TestSchemaColumns(t *testing.T) {
+	defs := schemaColumns(append(slices.Clip(knownFields), "unknown_future_field"), nil, nil, false)
+	types := make(map[string]string, len(defs))
+	for _, c := range defs {
+		types[c.name] = c.sqlType
+	}
+	for name, want := range map[string]string{
+		"elb_status_code":         "INTEGER",
+		"target_status_code":      "INTEGER",
+		"received_bytes":          "INTEGER",
+		"sent_bytes":              "INTEGER",
+		"matched_rule_priority":   "INTEGER",
+		"request_processing_time": "REAL",
+		"target_processing_time":  "REAL",
+		// other fields have no declared type, so values keep the
+		// storage class they are inserted with, as in databases
+		// created by earlier versions
+		"type":                    "",
+		"time":                    "",
+		"target_status_code_list": "",
+		"conn_trace_id":           "",
+		"unknown_future_field":    "",
+	} {
+		if got := types[name]; got != want {
+			t.Errorf("%s: got type %q, want %q", name, got, want)
+		}
+	}
+
+	defs = schemaColumns(knownFields, columnAliases, nil, true)
+	if len(defs) != len(knownFields) {
+		t.Fatalf("got %d columns, want %d", len(defs), len(knownFields))
+	}
+	for i, c := range defs {
+		want := knownFields[i]
+		if alias, ok := columnAliases[want]; ok {
+			want = alias
+		}
+		if c.name != want {
+			t.Errorf("column #%d: got %q, want %q", i+1, c.name, want)
+		}
+	}
+	if got := defs[slices.Index(knownFields, "request_processing_time")].sqlType; got != "INTEGER" {
+		t.Errorf("with milliseconds, processing time has type %q", got)
+	}
+}
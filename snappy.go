@@ -0,0 +1,15 @@
+//go:build snappy
+
+package main
+
+import (
+	"io"
+
+	"github.com/golang/snappy"
+)
+
+func init() {
+	// the framing format, as written by snzip and python-snappy streams;
+	// Hadoop's block format is different
+	decompressors[".snappy"] = func(r io.Reader) (io.Reader, error) { return snappy.NewReader(r), nil }
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// writeSQLDump writes the -sql-dump file, or to standard output if name is -.
+func writeSQLDump(ctx context.Context, name string, db *sql.DB, precision int) error {
+	if name == "-" {
+		return dumpSQL(ctx, os.Stdout, db, precision)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := dumpSQL(ctx, f, db, precision); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// dumpSQL writes the logs table as SQL statements creating and populating
+// it, portable to other SQL databases: column types are standard ones rather
+// than SQLite-specific, and rows are added with one INSERT statement each,
+// all within a single transaction. Floating-point numbers are written with the
+// precision, see formatFloat.
+func dumpSQL(ctx context.Context, w io.Writer, db *sql.DB, precision int) error {
+	rows, err := db.QueryContext(ctx, `SELECT name, type FROM pragma_table_info('logs') ORDER BY cid`)
+	if err != nil {
+		return err
+	}
+	var names, defs []string
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, quoteIdent(name))
+		defs = append(defs, "  "+quoteIdent(name)+" "+standardType(typ))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "CREATE TABLE logs (\n%s\n);\nBEGIN;\n", strings.Join(defs, ",\n"))
+	prefix := "INSERT INTO logs (" + strings.Join(names, ", ") + ") VALUES ("
+
+	if rows, err = db.QueryContext(ctx, `SELECT * FROM logs`); err != nil {
+		return err
+	}
+	defer rows.Close()
+	vals := make([]any, len(names))
+	ptrs := make([]any, len(names))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	var buf []byte
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		buf = append(buf[:0], prefix...)
+		for i, v := range vals {
+			if i != 0 {
+				buf = append(buf, ", "...)
+			}
+			buf = appendSQLValue(buf, v, precision)
+		}
+		buf = append(buf, ");\n"...)
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	bw.WriteString("COMMIT;\n")
+	return bw.Flush()
+}
+
+// standardType maps the declared SQLite column type to a standard SQL one.
+func standardType(typ string) string {
+	switch strings.ToUpper(typ) {
+	case "INTEGER":
+		return "BIGINT"
+	case "REAL":
+		return "DOUBLE PRECISION"
+	}
+	return "TEXT"
+}
+
+// quoteIdent quotes the identifier the standard SQL way; MySQL needs the
+// ANSI_QUOTES mode to accept it.
+func quoteIdent(s string) string { return `"` + strings.ReplaceAll(s, `"`, `""`) + `"` }
+
+// appendSQLValue appends v as an SQL literal to buf.
+func appendSQLValue(buf []byte, v any, precision int) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "NULL"...)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case float64:
+		return append(buf, formatFloat(v, precision)...)
+	case []byte:
+		return appendSQLValue(buf, string(v), precision)
+	case string:
+		buf = append(buf, '\'')
+		buf = append(buf, strings.ReplaceAll(v, "'", "''")...)
+		return append(buf, '\'')
+	}
+	buf = append(buf, '\'')
+	buf = append(buf, strings.ReplaceAll(fmt.Sprint(v), "'", "''")...)
+	return append(buf, '\'')
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/artyom/status"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// consumeQueue loads log files as S3 notifies the SQS queue of their
+// creation, see -sqs, until interrupted or stopped by -max-runtime. Messages
+// are deleted once all log files they announce are loaded; those that fail
+// to load are retried when SQS delivers the message again. Messages that are
+// not S3 event notifications are deleted right away, as no number of
+// redeliveries would make them load.
+//
+// Only notifications of log files of src are processed, either sent to the
+// queue directly or through an SNS topic: other load balancers may deliver
+// logs to the same bucket. Files are selected with the same options as when
+// listing the bucket.
+func consumeQueue(ctx context.Context, args *runArgs, albName string, src *logSource, line *status.Line) error {
+	cfg, err := awsConfig(ctx, args, albName)
+	if err != nil {
+		return err
+	}
+	if region := queueRegion(args.SQS); region != "" {
+		cfg.Region = region
+	}
+	if cfg.Region == "" {
+		return fmt.Errorf("cannot figure out the region of SQS queue %q, "+
+			"set AWS_REGION or configure region for the profile", args.SQS)
+	}
+	client := sqs.NewFromConfig(cfg)
+	dbName := databaseName(args, src)
+	if args.Database == "" {
+		if err := os.MkdirAll(filepath.Dir(dbName), 0777); err != nil {
+			return err
+		}
+	}
+	ld, err := openDatabase(ctx, args, dbName, src)
+	if err != nil {
+		return err
+	}
+	defer ld.db.Close()
+	opts := listOptionsFor(args)
+	var files, rows int
+	line.Print("Waiting for log files")
+receive:
+	for {
+		out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            &args.SQS,
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, m := range out.Messages {
+			objects, err := notifiedObjects(aws.ToString(m.Body), src.bucket)
+			if err != nil {
+				line.Print("")
+				log.Printf("Deleting SQS message %s, which cannot be processed: %v", aws.ToString(m.MessageId), err)
+			}
+			failed := false
+			for _, obj := range objects {
+				prefix, ok := src.owns(obj.key)
+				if !ok || !opts.accept(obj.key, prefix, obj.size) {
+					continue
+				}
+				n, err := ld.ingestLogFile(ctx, obj.key)
+				rows += n
+				if ctx.Err() != nil {
+					break receive
+				}
+				if err != nil {
+					line.Print("")
+					log.Printf("Loading %s: %v", obj.key, err)
+					failed = true
+					continue
+				}
+				files++
+				line.Printf("Loaded %d rows from %d log files, waiting for more", rows, files)
+			}
+			if failed {
+				continue
+			}
+			if _, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      &args.SQS,
+				ReceiptHandle: m.ReceiptHandle,
+			}); err != nil {
+				if ctx.Err() != nil {
+					break receive
+				}
+				return err
+			}
+		}
+	}
+	line.Print("")
+	log.Printf("Loaded %d rows from %d log files", rows, files)
+	if err := ld.db.Close(); err != nil {
+		return err
+	}
+	log.Println("Database file:", dbName)
+	if context.Cause(ctx) == errMaxRuntime {
+		return errMaxRuntime
+	}
+	return nil
+}
+
+// queueRegion returns the region of the SQS queue with the URL, such as
+// https://sqs.us-east-1.amazonaws.com/123456789012/name, or an empty string
+// if the URL doesn't tell it.
+func queueRegion(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return ""
+	}
+	switch parts := strings.Split(u.Hostname(), "."); {
+	case len(parts) > 2 && parts[0] == "sqs":
+		return parts[1]
+	case len(parts) > 2 && parts[1] == "queue":
+		// legacy region.queue.amazonaws.com
+		return parts[0]
+	}
+	return ""
+}
+
+// owns reports whether the S3 object with the key is a log file of the load
+// balancer, returning the listed prefix it is under, see logSource.prefixes.
+// The file name must have the load balancer id, or at least its name, if
+// the id is not known.
+func (src *logSource) owns(key string) (string, bool) {
+	t, ok := keyTime(key)
+	if !ok {
+		return "", false
+	}
+	// account_elasticloadbalancing_region_app.name.id_time_ip_random.log.gz
+	id := strings.SplitN(path.Base(key), "_", 6)[3]
+	if src.arn != "" {
+		if id != fileID(src.arn) {
+			return "", false
+		}
+	} else if parts := strings.Split(id, "."); len(parts) != 3 || parts[1] != src.name {
+		return "", false
+	}
+	for _, prefix := range src.prefixes(t) {
+		if strings.HasPrefix(key, prefix+"/") {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// fileID returns the load balancer id log file names include, such as
+// app.my-lb.50dc6c495c0c9188 for the load balancer with the ARN
+// arn:aws:elasticloadbalancing:region:account:loadbalancer/app/my-lb/50dc6c495c0c9188.
+func fileID(arn string) string {
+	resource := strings.TrimPrefix(arn[strings.LastIndexByte(arn, ':')+1:], "loadbalancer/")
+	return strings.ReplaceAll(resource, "/", ".")
+}
+
+// notifiedObject is an S3 object an event notification announces.
+type notifiedObject struct {
+	key  string
+	size int64
+}
+
+// notifiedObjects returns log files in the bucket that the S3 event
+// notification announces. The notification may be wrapped into an SNS one.
+// Test events, sent when notifications are configured, have no objects.
+func notifiedObjects(body, bucket string) ([]notifiedObject, error) {
+	var sns struct {
+		Type    string
+		Message string
+	}
+	if err := json.Unmarshal([]byte(body), &sns); err != nil {
+		return nil, err
+	}
+	if sns.Type == "Notification" {
+		body = sns.Message
+	}
+	var event struct {
+		Records []struct {
+			EventName string `json:"eventName"`
+			S3        struct {
+				Bucket struct {
+					Name string `json:"name"`
+				} `json:"bucket"`
+				Object struct {
+					Key  string `json:"key"`
+					Size int64  `json:"size"`
+				} `json:"object"`
+			} `json:"s3"`
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return nil, err
+	}
+	var out []notifiedObject
+	for _, r := range event.Records {
+		if !strings.HasPrefix(r.EventName, "ObjectCreated:") {
+			continue
+		}
+		if r.S3.Bucket.Name != bucket {
+			return nil, fmt.Errorf("notification about bucket %q, logs are in %q", r.S3.Bucket.Name, bucket)
+		}
+		// keys are URL-encoded in notifications
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, notifiedObject{key: key, size: r.S3.Object.Size})
+	}
+	return out, nil
+}
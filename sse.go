@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// sseCustomerKey is the key log files are encrypted with when using
+// server-side encryption with customer-provided keys (SSE-C).
+type sseCustomerKey struct {
+	key string // base64-encoded 256-bit key
+	md5 string // base64-encoded MD5 digest of the key
+}
+
+// parseSSECustomerKey parses the -sse-customer-key value, which is a
+// base64-encoded 256-bit key.
+func parseSSECustomerKey(s string) (*sseCustomerKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return nil, errors.New("-sse-customer-key must be a base64-encoded 256-bit key")
+	}
+	sum := md5.Sum(b)
+	return &sseCustomerKey{key: s, md5: base64.StdEncoding.EncodeToString(sum[:])}, nil
+}
+
+// algorithm, keyValue, and keyMD5 return values for the SSE-C request fields, or
+// nils if k is nil.
+func (k *sseCustomerKey) algorithm() *string {
+	if k == nil {
+		return nil
+	}
+	return aws.String(string(s3types.ServerSideEncryptionAes256))
+}
+
+func (k *sseCustomerKey) keyValue() *string {
+	if k == nil {
+		return nil
+	}
+	return &k.key
+}
+
+func (k *sseCustomerKey) keyMD5() *string {
+	if k == nil {
+		return nil
+	}
+	return &k.md5
+}
+
+// explainAccessDenied adds a hint to the access denied err returned by
+// GetObject if the object is encrypted with a KMS key: reading such objects
+// also requires the kms:Decrypt permission on the key, and missing it is
+// reported as a plain access denied error. Object metadata, including the
+// key ARN, can be read without that permission.
+func explainAccessDenied(ctx context.Context, client *s3.Client, bucket, key string, owner *string, err error) error {
+	if e := smithy.APIError(nil); !errors.As(err, &e) || e.ErrorCode() != "AccessDenied" {
+		return err
+	}
+	out, herr := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:              &bucket,
+		Key:                 &key,
+		ExpectedBucketOwner: owner,
+	})
+	if herr != nil {
+		return err
+	}
+	switch out.ServerSideEncryption {
+	case s3types.ServerSideEncryptionAwsKms, s3types.ServerSideEncryptionAwsKmsDsse:
+		return fmt.Errorf("%w\nThe object is encrypted with KMS key %s: make sure you are allowed kms:Decrypt on it",
+			err, aws.ToString(out.SSEKMSKeyId))
+	}
+	return err
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// runStats are aggregates of the loaded log entries printed by -stats-only.
+type runStats struct {
+	Requests   int64            `json:"requests"`
+	SampleRate float64          `json:"sample_rate"`
+	Classes    map[string]int64 `json:"status_classes"`
+	// Latency holds percentiles of processing times, in seconds, keyed by
+	// log field name, then by percentile name ("p50", "p90", "p99")
+	Latency    map[string]map[string]float64 `json:"latency"`
+	TopClients []clientCount                 `json:"top_clients"`
+	// Missing holds the fraction of entries without a value, keyed by
+	// column name, see missingRates
+	Missing map[string]float64 `json:"missing"`
+}
+
+type clientCount struct {
+	Client   string `json:"client"`
+	Requests int64  `json:"requests"`
+}
+
+// statsPercentiles are latency percentiles reported by -stats-only.
+var statsPercentiles = []struct {
+	name string
+	p    float64
+}{{"p50", .5}, {"p90", .9}, {"p99", .99}}
+
+// topClientsLimit is the number of the most active clients reported by
+// -stats-only.
+const topClientsLimit = 10
+
+// collectStats computes aggregates of the loaded log entries.
+func collectStats(ctx context.Context, db *sql.DB, sampleRate float64) (*runStats, error) {
+	out := &runStats{
+		SampleRate: sampleRate,
+		Classes:    make(map[string]int64),
+		Latency:    make(map[string]map[string]float64),
+		TopClients: []clientCount{},
+	}
+	classes, err := statusClasses(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range classes {
+		out.Classes[fmt.Sprintf("%dxx", c.class)] = c.count
+	}
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM logs`).Scan(&out.Requests); err != nil {
+		return nil, err
+	}
+	ps := make([]float64, len(statsPercentiles))
+	for i, p := range statsPercentiles {
+		ps[i] = p.p
+	}
+	for _, field := range []string{"request_processing_time", "target_processing_time", "response_processing_time"} {
+		vals, err := percentiles(ctx, db, field, ps...)
+		if err != nil {
+			return nil, err
+		}
+		if vals == nil {
+			continue
+		}
+		m := make(map[string]float64, len(vals))
+		for i, v := range vals {
+			m[statsPercentiles[i].name] = v
+		}
+		out.Latency[field] = m
+	}
+	query, err := expandColumns(ctx, db, `SELECT rtrim(rtrim({client_port}, '0123456789'), ':'), count(*)
+		FROM logs GROUP BY 1 ORDER BY 2 DESC LIMIT ?`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, query, topClientsLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c clientCount
+		if err := rows.Scan(&c.Client, &c.Requests); err != nil {
+			return nil, err
+		}
+		out.TopClients = append(out.TopClients, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if out.Missing, err = missingRates(ctx, db, out.Requests); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// missingRates returns the fraction of the total entries having no value in
+// each logs table column: either NULL, or a placeholder ALB logs for missing
+// values, which is "-" or an empty string for text fields, and -1 for numeric
+// ones. A column that is mostly missing may point at a specific failure, such
+// as requests that never reached a target.
+func missingRates(ctx context.Context, db *sql.DB, total int64) (map[string]float64, error) {
+	cols, err := tableColumnList(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]float64, len(cols))
+	if total == 0 || len(cols) == 0 {
+		return out, nil
+	}
+	exprs := make([]string, len(cols))
+	for i, name := range cols {
+		col := quoteIdent(name)
+		exprs[i] = fmt.Sprintf(`total(%[1]s IS NULL OR %[1]s IN ('', '-')
+			OR (typeof(%[1]s) IN ('integer', 'real') AND %[1]s < 0))`, col)
+	}
+	counts := make([]float64, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range counts {
+		ptrs[i] = &counts[i]
+	}
+	if err := db.QueryRowContext(ctx, `SELECT `+strings.Join(exprs, ", ")+` FROM logs`).Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	for i, name := range cols {
+		out[name] = counts[i] / float64(total)
+	}
+	return out, nil
+}
+
+// writeSummaryFile writes aggregates of the loaded log entries to the file
+// for -summary-out: as JSON, in the -stats-only format, if the file name has
+// the .json extension, otherwise as a plain text report.
+func writeSummaryFile(ctx context.Context, name string, db *sql.DB, sampleRate float64) error {
+	stats, err := collectStats(ctx, db, sampleRate)
+	if err != nil {
+		return err
+	}
+	var b []byte
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		if b, err = json.MarshalIndent(stats, "", "  "); err != nil {
+			return err
+		}
+		b = append(b, '\n')
+	} else {
+		var buf bytes.Buffer
+		writeStatsText(&buf, stats)
+		b = buf.Bytes()
+	}
+	return os.WriteFile(name, b, 0666)
+}
+
+// writeStatsText writes stats as a human-readable report.
+func writeStatsText(w io.Writer, stats *runStats) {
+	fmt.Fprintf(w, "Requests: %d\n", stats.Requests)
+	if stats.SampleRate < 1 {
+		fmt.Fprintf(w, "Sample rate: %v\n", stats.SampleRate)
+	}
+	for _, class := range sortedKeys(stats.Classes) {
+		n := stats.Classes[class]
+		fmt.Fprintf(w, "  %s %10d %6.1f%%\n", class, n, share(n, stats.Requests))
+	}
+	if len(stats.Latency) != 0 {
+		fmt.Fprintln(w, "\nLatency, seconds:")
+		fmt.Fprintf(w, "  %-26s", "")
+		for _, p := range statsPercentiles {
+			fmt.Fprintf(w, " %8s", p.name)
+		}
+		fmt.Fprintln(w)
+		for _, field := range sortedKeys(stats.Latency) {
+			fmt.Fprintf(w, "  %-26s", field)
+			for _, p := range statsPercentiles {
+				fmt.Fprintf(w, " %8.3f", stats.Latency[field][p.name])
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	if len(stats.TopClients) != 0 {
+		fmt.Fprintln(w, "\nTop clients:")
+		for _, c := range stats.TopClients {
+			fmt.Fprintf(w, "  %-40s %10d\n", c.Client, c.Requests)
+		}
+	}
+	var missing []string
+	for _, col := range sortedKeys(stats.Missing) {
+		if stats.Missing[col] > 0 {
+			missing = append(missing, col)
+		}
+	}
+	if len(missing) != 0 {
+		// most often missing first
+		slices.SortStableFunc(missing, func(a, b string) int {
+			return cmp.Compare(stats.Missing[b], stats.Missing[a])
+		})
+		fmt.Fprintln(w, "\nMissing values:")
+		for _, col := range missing {
+			fmt.Fprintf(w, "  %-26s %6.1f%%\n", col, 100*stats.Missing[col])
+		}
+	}
+}
+
+// sortedKeys returns keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// printSummary writes a short overview of the loaded log entries to w: the
+// total number of requests and their distribution over ELB status code
+// classes. If color is true, 4xx and 5xx classes are highlighted.
+func printSummary(ctx context.Context, w io.Writer, db *sql.DB, color bool) error {
+	classes, err := statusClasses(ctx, db)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, c := range classes {
+		total += c.count
+	}
+	fmt.Fprintf(w, "Requests: %d\n", total)
+	for _, c := range classes {
+		text := fmt.Sprintf("  %dxx %10d %6.1f%%", c.class, c.count, 100*float64(c.count)/float64(total))
+		if color {
+			text = colorizeClass(c.class, text)
+		}
+		fmt.Fprintln(w, text)
+	}
+	return nil
+}
+
+// classCount is the number of requests with ELB status codes of a single
+// class: 2 for 2xx, 3 for 3xx, and so on.
+type classCount struct{ class, count int64 }
+
+// statusClasses returns the number of requests per ELB status code class,
+// ordered by class.
+func statusClasses(ctx context.Context, db *sql.DB) ([]classCount, error) {
+	col, err := columnName(ctx, db, "elb_status_code")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT "`+col+`"/100, count(*) FROM logs
+		WHERE typeof("`+col+`")='integer' GROUP BY 1 ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []classCount
+	for rows.Next() {
+		var c classCount
+		if err := rows.Scan(&c.class, &c.count); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// percentiles returns values of the numeric log field at each of the
+// percentiles ps, given as fractions in the [0,1] range. Negative values,
+// which ALB uses to mark missing data, are ignored. Processing times are
+// returned in seconds, even if stored with -time-ms. If the field has no
+// values, percentiles returns nil.
+func percentiles(ctx context.Context, db *sql.DB, field string, ps ...float64) ([]float64, error) {
+	col, err := columnName(ctx, db, field)
+	if err != nil {
+		return nil, err
+	}
+	col = `"` + col + `"`
+	var n int64
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM logs WHERE `+col+` >= 0`).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	out := make([]float64, len(ps))
+	for i, p := range ps {
+		offset := min(int64(p*float64(n)), n-1)
+		if err := db.QueryRowContext(ctx, `SELECT `+col+` FROM logs WHERE `+col+` >= 0
+			ORDER BY 1 LIMIT 1 OFFSET ?`, offset).Scan(&out[i]); err != nil {
+			return nil, err
+		}
+	}
+	if isProcessingTime(field) {
+		scale := timeScale(ctx, db)
+		for i := range out {
+			out[i] /= scale
+		}
+	}
+	return out, nil
+}
+
+// colorizeClass highlights text describing 4xx and 5xx status code classes.
+func colorizeClass(class int64, text string) string {
+	switch class {
+	case 4:
+		return ansiYellow + text + ansiReset
+	case 5:
+		return ansiRed + text + ansiReset
+	}
+	return text
+}
+
+const (
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiReset  = "\x1b[0m"
+)
+
+// useColor reports whether output to f should be colorized according to mode,
+// which is one of "auto", "always", or "never". In the "auto" mode, color is
+// only used if f is a terminal and the NO_COLOR environment variable is unset
+// or empty (see https://no-color.org).
+func useColor(mode string, f *os.File) bool {
+	switch mode {
+	case "always":
+		return true
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(f.Fd()))
+	}
+	return false
+}
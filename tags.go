@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// tagWorkers is the number of concurrent GetObjectTagging requests.
+const tagWorkers = 16
+
+// objectTag is a single S3 object tag, as set with -tag.
+type objectTag struct{ key, value string }
+
+// parseTag parses the key=value form of -tag.
+func parseTag(s string) (objectTag, error) {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return objectTag{}, fmt.Errorf("invalid tag %q, must be in key=value form", s)
+	}
+	return objectTag{key: k, value: v}, nil
+}
+
+// filterByTag returns keys of objects having the tag, preserving their order.
+// Once limit keys are found (if limit is positive), the remaining objects are
+// not checked.
+func filterByTag(ctx context.Context, client *s3.Client, bucket string, owner *string, keys []string, tag objectTag, limit int) ([]string, error) {
+	var out []string
+	// keys are checked in chunks, each one concurrently
+	for len(keys) != 0 && (limit <= 0 || len(out) < limit) {
+		chunk := keys[:min(len(keys), tagWorkers)]
+		keys = keys[len(chunk):]
+		match := make([]bool, len(chunk))
+		errs := make([]error, len(chunk))
+		var wg sync.WaitGroup
+		for i, key := range chunk {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+					Bucket:              &bucket,
+					Key:                 &key,
+					ExpectedBucketOwner: owner,
+				})
+				if err != nil {
+					errs[i] = fmt.Errorf("getting tags of %q: %w", key, err)
+					return
+				}
+				for _, t := range res.TagSet {
+					if aws.ToString(t.Key) == tag.key && aws.ToString(t.Value) == tag.value {
+						match[i] = true
+						break
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		for i, key := range chunk {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			if match[i] && (limit <= 0 || len(out) < limit) {
+				out = append(out, key)
+			}
+		}
+	}
+	return out, nil
+}
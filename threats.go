@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// prefixSet is a set of IP address ranges with lookups taking a single map
+// access per distinct prefix length in the set, however large it is.
+type prefixSet struct {
+	lengths  []int // distinct prefix lengths, longest first
+	prefixes map[netip.Prefix]struct{}
+}
+
+// contains reports whether addr is within any range of the set.
+func (s *prefixSet) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, bits := range s.lengths {
+		if bits > addr.BitLen() {
+			continue
+		}
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if _, ok := s.prefixes[p]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readThreatList reads IP addresses and CIDR ranges listed in the file, one
+// per line. Empty lines and text after # are ignored.
+func readThreatList(name string) (*prefixSet, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	set := &prefixSet{prefixes: make(map[netip.Prefix]struct{})}
+	sc := bufio.NewScanner(f)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		s, _, _ := strings.Cut(sc.Text(), "#")
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		var p netip.Prefix
+		if addr, err := netip.ParseAddr(s); err == nil {
+			addr = addr.Unmap()
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		} else if p, err = netip.ParsePrefix(s); err != nil {
+			return nil, fmt.Errorf("%s:%d: %q is neither an IP address nor a CIDR range", name, lineNo, s)
+		}
+		p = p.Masked()
+		set.prefixes[p] = struct{}{}
+		if !slices.Contains(set.lengths, p.Bits()) {
+			set.lengths = append(set.lengths, p.Bits())
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(set.lengths)
+	slices.Reverse(set.lengths)
+	return set, nil
+}
+
+// clientFlaggedColumn returns the client_flagged column, added with
+// -threat-list, telling whether the client address is in the set. It's NULL
+// for entries without a valid client address.
+func clientFlaggedColumn(cols []string, set *prefixSet) derivedColumn {
+	client := slices.Index(cols, "client_port")
+	return derivedColumn{
+		name:    "client_flagged",
+		sqlType: "INTEGER",
+		value: func(e *logEntry) any {
+			ap, err := netip.ParseAddrPort(e.field(client))
+			if err != nil {
+				return nil
+			}
+			if set.contains(ap.Addr()) {
+				return 1
+			}
+			return 0
+		},
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// isProcessingTime reports whether the log field holds a processing time,
+// logged in seconds.
+func isProcessingTime(field string) bool { return strings.HasSuffix(field, "_processing_time") }
+
+// milliseconds converts a processing time in seconds to integer milliseconds,
+// as stored with -time-ms. ALB logs -1 when the value is not available, which
+// is stored as NULL.
+func milliseconds(v string) any {
+	x, err := strconv.ParseFloat(v, 64)
+	if err != nil || x < 0 {
+		return nil
+	}
+	return int64(math.Round(x * 1000))
+}
+
+// recordTimeUnit saves the unit of processing times ("s" or "ms") into the
+// run_meta table, refusing to reuse a database populated with a different
+// unit. Databases created before the unit was recorded use seconds.
+func recordTimeUnit(ctx context.Context, db *sql.DB, ms, reused bool) error {
+	unit := "s"
+	if ms {
+		unit = "ms"
+	}
+	var prev string
+	err := db.QueryRowContext(ctx, `SELECT value FROM run_meta WHERE key='time_unit'`).Scan(&prev)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if reused && ms {
+			return errors.New("database was populated with processing times in seconds, cannot add entries with -time-ms")
+		}
+		_, err = db.ExecContext(ctx, `INSERT INTO run_meta VALUES('time_unit', ?)`, unit)
+		return err
+	case err != nil:
+		return err
+	case prev != unit:
+		if prev == "ms" {
+			return errors.New("database was populated with -time-ms, cannot add entries with processing times in seconds")
+		}
+		return errors.New("database was populated with processing times in seconds, cannot add entries with -time-ms")
+	}
+	return nil
+}
+
+// timeScale returns the number processing times stored in the database must
+// be divided by to get seconds.
+func timeScale(ctx context.Context, db *sql.DB) float64 {
+	var unit string
+	if err := db.QueryRowContext(ctx, `SELECT value FROM run_meta WHERE key='time_unit'`).Scan(&unit); err == nil && unit == "ms" {
+		return 1000
+	}
+	return 1
+}
@@ -1,11 +1,17 @@
 // Command update-fields fetches the list of AWS Elastic Load Balancer access
 // log fields as described at
 // https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html#access-log-entry-syntax
+//
+// AWS only ever appends new fields to the end of log entries, so the command
+// refuses to overwrite fields.txt with a list that drops or reorders any of
+// the known fields: this most likely means that the page layout changed and
+// the scraper needs fixing. Use the -force flag to overwrite it anyway.
 package main
 
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -19,12 +25,14 @@ import (
 
 func main() {
 	log.SetFlags(0)
-	if err := run(); err != nil {
+	force := flag.Bool("force", false, "overwrite fields.txt even if known fields are missing from the new list")
+	flag.Parse()
+	if err := run(*force); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run() error {
+func run(force bool) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html", nil)
@@ -94,10 +102,30 @@ func run() error {
 		}
 		return errors.New(b.String())
 	}
+	if b, err := os.ReadFile("fields.txt"); err == nil && !force {
+		if err := checkAppendOnly(strings.Split(strings.TrimSpace(string(b)), "\n"), columns); err != nil {
+			return fmt.Errorf("%w; use -force to overwrite fields.txt anyway", err)
+		}
+	}
 	out := strings.Join(columns, "\n")
 	return os.WriteFile("fields.txt", []byte(out), 0666)
 }
 
+// checkAppendOnly returns an error if the new list of fields does not start
+// with all the known fields, in the same order.
+func checkAppendOnly(known, columns []string) error {
+	for i, name := range known {
+		if i >= len(columns) {
+			return fmt.Errorf("new list has only %d fields, known fields missing: %s",
+				len(columns), strings.Join(known[i:], ", "))
+		}
+		if columns[i] != name {
+			return fmt.Errorf("field #%d is %q, previously it was %q", i+1, columns[i], name)
+		}
+	}
+	return nil
+}
+
 func processTable(table *html.Node) ([]string, error) {
 	var column int
 	var fn func(*html.Node)
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is the time window log files are selected from: those delivered
+// within the half-open [From, To) interval.
+type Window struct {
+	From, To time.Time
+}
+
+// ParseWindow parses the -time value, interpreting it in the loc time zone.
+// It's either a single reference time starting a window of the default
+// length, or a start..end range; see parseTime for supported time formats.
+func ParseWindow(s string, loc *time.Location) (Window, error) {
+	if start, end, ok := strings.Cut(s, ".."); ok {
+		return parseTimeRange(start, end, loc)
+	}
+	t, err := parseTime(s, loc)
+	if err != nil {
+		return Window{}, err
+	}
+	return Window{From: t, To: t.Add(windowSize)}, nil
+}
+
+// Duration returns the window length.
+func (w Window) Duration() time.Duration { return w.To.Sub(w.From) }
+
+// timeLocation returns the time zone -time and -compare are interpreted in:
+// the local one, UTC if utc is set, or the named one.
+func timeLocation(utc bool, name string) (*time.Location, error) {
+	if name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("-timezone: %w", err)
+		}
+		return loc, nil
+	}
+	if utc {
+		return time.UTC, nil
+	}
+	return time.Local, nil
+}
+
+// parseTimeRange parses the start..end form of -time. Both ends take the
+// same formats as -time; if end is hh:mm, it's taken on the start date.
+func parseTimeRange(start, end string, loc *time.Location) (Window, error) {
+	from, err := parseTime(start, loc)
+	if err != nil {
+		return Window{}, fmt.Errorf("-time range start: %w", err)
+	}
+	var to time.Time
+	if t, err := time.ParseInLocation("15:04", end, loc); err == nil {
+		h, m, _ := t.Clock()
+		to = time.Date(from.Year(), from.Month(), from.Day(), h, m, 0, 0, loc)
+	} else if to, err = parseTime(end, loc); err != nil {
+		return Window{}, fmt.Errorf("-time range end: %w", err)
+	}
+	if !to.After(from) {
+		return Window{}, fmt.Errorf("-time range end %s is not after its start %s",
+			to.Format(timeLayout), from.Format(timeLayout))
+	}
+	return Window{From: from, To: to}, nil
+}
+
+// parseTime parses the reference time in one of the formats supported by the
+// -time flag, interpreting it in the loc time zone. Empty string stands for
+// the current time.
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	switch s {
+	case "", "now":
+		return time.Now().In(loc).Add(-windowSize), nil
+	case "yesterday":
+		return time.Now().In(loc).AddDate(0, 0, -1).Add(-windowSize), nil
+	}
+	if hasOnlyDigits(s) {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if len(s) == 13 {
+			return time.UnixMilli(n).In(loc), nil
+		}
+		return time.Unix(n, 0).In(loc), nil
+	}
+	if t, err := time.ParseInLocation("15:04", s, loc); err == nil {
+		h, m, _ := t.Clock()
+		now := time.Now().In(loc)
+		return time.Date(now.Year(), now.Month(), now.Day(), h, m, 0, 0, loc), nil
+	}
+	return time.ParseInLocation(timeLayout, s, loc)
+}
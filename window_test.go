@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	plus3 := time.FixedZone("UTC+3", 3*60*60)
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tc := range []struct {
+		s        string
+		loc      *time.Location
+		from, to time.Time
+	}{
+		{"2024-01-02T10:05", time.UTC, at("2024-01-02T10:05:00Z"), at("2024-01-02T10:10:00Z")},
+		{"2024-01-02T10:05", plus3, at("2024-01-02T07:05:00Z"), at("2024-01-02T07:10:00Z")},
+		{"1704189900", plus3, at("2024-01-02T10:05:00Z"), at("2024-01-02T10:10:00Z")},
+		{"1704189900000", time.UTC, at("2024-01-02T10:05:00Z"), at("2024-01-02T10:10:00Z")},
+		{"2024-01-02T10:05..10:35", time.UTC, at("2024-01-02T10:05:00Z"), at("2024-01-02T10:35:00Z")},
+		{"2024-01-02T10:05..10:35", plus3, at("2024-01-02T07:05:00Z"), at("2024-01-02T07:35:00Z")},
+		{"2024-01-02T23:30..2024-01-03T00:30", time.UTC, at("2024-01-02T23:30:00Z"), at("2024-01-03T00:30:00Z")},
+		{"1704189900..1704193500", time.UTC, at("2024-01-02T10:05:00Z"), at("2024-01-02T11:05:00Z")},
+	} {
+		w, err := ParseWindow(tc.s, tc.loc)
+		if err != nil {
+			t.Errorf("%q in %s: %v", tc.s, tc.loc, err)
+			continue
+		}
+		if !w.From.Equal(tc.from) || !w.To.Equal(tc.to) {
+			t.Errorf("%q in %s: got [%s, %s), want [%s, %s)", tc.s, tc.loc, w.From, w.To, tc.from, tc.to)
+		}
+		if w.From.Location() != tc.loc {
+			t.Errorf("%q in %s: window start is in %s", tc.s, tc.loc, w.From.Location())
+		}
+		if got, want := w.Duration(), tc.to.Sub(tc.from); got != want {
+			t.Errorf("%q in %s: got duration %s, want %s", tc.s, tc.loc, got, want)
+		}
+	}
+}
+
+func TestParseWindowErrors(t *testing.T) {
+	for _, tc := range []struct {
+		s, want string
+	}{
+		{"2024-01-02 10:05", "cannot parse"},
+		{"10:05pm", "cannot parse"},
+		{"2024-01-02T10:05..10:05", "is not after its start"},
+		{"2024-01-02T10:05..09:00", "is not after its start"},
+		{"2024-01-02T10:05..2024-01-01T10:05", "is not after its start"},
+		{"tomorrow..2024-01-02T10:05", "-time range start"},
+		{"2024-01-02T10:05..later", "-time range end"},
+	} {
+		if _, err := ParseWindow(tc.s, time.UTC); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got error %v, want one containing %q", tc.s, err, tc.want)
+		}
+	}
+}
+
+func TestParseWindowRelative(t *testing.T) {
+	for _, tc := range []struct {
+		s       string
+		fromAgo time.Duration
+	}{
+		{"", windowSize},
+		{"now", windowSize},
+		{"yesterday", 24*time.Hour + windowSize},
+	} {
+		before := time.Now()
+		w, err := ParseWindow(tc.s, time.UTC)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.s, err)
+		}
+		after := time.Now()
+		// yesterday may be 23 or 25 hours ago around daylight saving
+		// time switches, but not in UTC
+		if w.From.Before(before.Add(-tc.fromAgo)) || w.From.After(after.Add(-tc.fromAgo)) {
+			t.Errorf("%q: window starts at %s, %s before now", tc.s, w.From, after.Sub(w.From))
+		}
+		if w.Duration() != windowSize {
+			t.Errorf("%q: got duration %s, want %s", tc.s, w.Duration(), windowSize)
+		}
+	}
+
+	// hh:mm is taken on the current date
+	w, err := ParseWindow("00:00", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Now().UTC().Truncate(24 * time.Hour); !w.From.Equal(want) && !w.From.Equal(want.AddDate(0, 0, -1)) {
+		t.Errorf("00:00: window starts at %s, want %s", w.From, want)
+	}
+}
+
+func TestTimeLocation(t *testing.T) {
+	if loc, err := timeLocation(false, ""); err != nil || loc != time.Local {
+		t.Errorf("default: got %v, %v, want the local time zone", loc, err)
+	}
+	if loc, err := timeLocation(true, ""); err != nil || loc != time.UTC {
+		t.Errorf("-utc: got %v, %v, want UTC", loc, err)
+	}
+	if loc, err := timeLocation(true, "UTC"); err != nil || loc.String() != "UTC" {
+		t.Errorf("-timezone UTC: got %v, %v", loc, err)
+	}
+	if _, err := timeLocation(false, "Nowhere/Special"); err == nil || !strings.HasPrefix(err.Error(), "-timezone: ") {
+		t.Errorf("unknown -timezone: got error %v", err)
+	}
+}
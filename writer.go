@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"sync"
+)
+
+// defaultBatchSize is the number of rows per batch sent by parsing workers to
+// the writer, unless -commit-every is set.
+const defaultBatchSize = 10000
+
+// rowBatch is a chunk of consecutive rows parsed from a single log file.
+// Parsing workers send batches to the only writer goroutine, which adds each
+// one to the database in its own transaction: SQLite allows a single writer
+// at a time anyway.
+type rowBatch struct {
+	key   string
+	rows  [][]any
+	lines int           // number of lines of the file processed so far
+	last  bool          // whether this is the final batch of the file
+	out   pendingOutput // -log-out and -jsonl-out output of rows, written once they are committed
+	span  *span         // span of loading the file, ended by the writer, see startSpan
+}
+
+// fileResult is the outcome of loading a single log file.
+type fileResult struct {
+	key  string
+	rows int // number of added rows
+	err  error
+}
+
+// ingestParallel loads log files identified by keys, stopping after limit
+// files were loaded. Files are downloaded by the given number of workers, at
+// most decoders of them decompress and parse downloaded files at the same
+// time, while rows are written to the database by a single goroutine.
+//
+// Workers and the writer are connected by a channel buffered for a single
+// batch per worker: once it's full, workers block until the writer catches
+// up, so memory use is bounded however slow the database is.
+//
+// Results are reported to fn as files complete, in no particular order. If fn
+// returns false, no more files are started. The function returns once all
+// started files are complete.
+func (l *loader) ingestParallel(ctx context.Context, keys []string, workers, decoders, limit int, fn func(fileResult) bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	batchSize := defaultBatchSize
+	if l.commitEvery > 0 {
+		batchSize = l.commitEvery
+	}
+	todo := make(chan string)
+	decodeSlots := make(chan struct{}, decoders)
+	batches := make(chan rowBatch, workers)
+	results := make(chan fileResult)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range todo {
+				if alreadyImported(ctx, l.db, key) {
+					results <- fileResult{key: key}
+					continue
+				}
+				ctx, s := startSpan(ctx, "ingest")
+				s.set("key", key)
+				// otherwise the writer ends the span once
+				// rows of the file are added
+				if err := l.produce(ctx, key, batchSize, decodeSlots, batches); err != nil {
+					s.done(err)
+					results <- fileResult{key: key, err: err}
+				}
+			}
+		}()
+	}
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		l.write(ctx, batches, results)
+	}()
+
+	// a file may get more than one result: when the writer fails, the
+	// worker still parsing it fails too on cancellation; only the first
+	// result of each file counts
+	inflight := make(map[string]struct{})
+	var next, done int
+	stop := false
+	for {
+		var send chan string
+		if !stop && next < len(keys) && done+len(inflight) < limit {
+			send = todo
+		}
+		if send == nil && len(inflight) == 0 {
+			break
+		}
+		var key string
+		if send != nil {
+			key = keys[next]
+		}
+		select {
+		case send <- key:
+			next++
+			inflight[key] = struct{}{}
+		case r := <-results:
+			if _, ok := inflight[r.key]; !ok {
+				continue
+			}
+			delete(inflight, r.key)
+			if r.err == nil {
+				done++
+			}
+			if !fn(r) {
+				stop = true
+				cancel()
+			}
+		}
+	}
+	close(todo)
+	go func() {
+		wg.Wait()
+		close(batches)
+	}()
+	// drain late duplicate results until everybody is done
+	for {
+		select {
+		case <-results:
+		case <-writerDone:
+			return
+		}
+	}
+}
+
+// produce downloads the log file, then parses it and sends its rows to
+// batches. The last batch, which may be empty, is marked as such.
+//
+// Decompression and parsing are CPU-bound, so they only start once a slot in
+// decodeSlots is taken. Downloading is not, so the file is read into memory
+// before that: log files are at most tens of megabytes compressed.
+func (l *loader) produce(ctx context.Context, key string, batchSize int, decodeSlots chan struct{}, batches chan<- rowBatch) error {
+	send := func(b rowBatch) error {
+		select {
+		case batches <- b:
+			return nil
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+	_, s := startSpan(ctx, "download")
+	rc, err := l.open(ctx, key)
+	if err != nil {
+		s.done(err)
+		return err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	s.set("bytes", len(data))
+	s.done(err)
+	if err != nil {
+		return err
+	}
+	select {
+	case decodeSlots <- struct{}{}:
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+	defer func() { <-decodeSlots }()
+	_, s = startSpan(ctx, "parse")
+	b := rowBatch{key: key, span: spanFrom(ctx)}
+	var out pendingOutput
+	err = l.decode(bytes.NewReader(data), key, l.resumeLine(ctx, key), &out, func(row []any, lineNo int) error {
+		// row is reused by parse, see its documentation
+		b.rows = append(b.rows, slices.Clone(row))
+		b.lines = lineNo
+		if len(b.rows) < batchSize {
+			return nil
+		}
+		// out is appended to before emit is called, so it
+		// includes the output of this row
+		b.out, out = out, pendingOutput{}
+		if err := send(b); err != nil {
+			return err
+		}
+		b = rowBatch{key: key, span: b.span}
+		return nil
+	})
+	s.done(err)
+	if err != nil {
+		return err
+	}
+	b.last = true
+	b.out = out
+	return send(b)
+}
+
+// write adds batches to the database until the channel is closed. Once the
+// last batch of a file is written, or writing of any batch fails, the
+// outcome is sent to results, and the span of loading the file ends.
+func (l *loader) write(ctx context.Context, batches <-chan rowBatch, results chan<- fileResult) {
+	query := insertStatement(l.columns)
+	rows := make(map[string]int)
+	failed := make(map[string]struct{})
+	for b := range batches {
+		if _, ok := failed[b.key]; ok {
+			continue
+		}
+		s := b.span.child("insert")
+		s.set("rows", len(b.rows))
+		err := l.writeBatch(ctx, query, b)
+		if err == nil {
+			err = l.flushOutput(&b.out)
+		}
+		s.done(err)
+		if err != nil {
+			failed[b.key] = struct{}{}
+			b.span.done(err)
+			results <- fileResult{key: b.key, rows: rows[b.key], err: err}
+			continue
+		}
+		rows[b.key] += len(b.rows)
+		if b.last {
+			b.span.set("rows", rows[b.key])
+			b.span.done(nil)
+			results <- fileResult{key: b.key, rows: rows[b.key]}
+			delete(rows, b.key)
+		}
+	}
+}
+
+// writeBatch adds rows of a single batch in a transaction, recording progress
+// of the file the same way ingestLogFile does.
+func (l *loader) writeBatch(ctx context.Context, query string, b rowBatch) error {
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	st, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	for _, row := range b.rows {
+		if _, err := st.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	if b.last {
+		err = markImported(ctx, tx, b.key)
+	} else {
+		err = markPartial(ctx, tx, b.key, b.lines)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
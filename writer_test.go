@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/artyom/status"
+)
+
+// writeLogFiles writes n gzip-compressed log files of different lengths to
+// dir, returning their names and the number of entries in each.
+func writeLogFiles(t *testing.T, dir string, n int) ([]string, map[string]int) {
+	t.Helper()
+	fixtures := []string{lineHTTP, lineQuotedAgent, lineIPv6, lineGRPC, lineWAF}
+	var keys []string
+	counts := make(map[string]int)
+	for i := range n {
+		lines := make([]string, 40+i*13)
+		for j := range lines {
+			lines[j] = fixtures[(i+j)%len(fixtures)]
+		}
+		name := filepath.Join(dir, fmt.Sprintf("file%02d.log.gz", i))
+		b, err := io.ReadAll(gzipLines(t, lines...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, b, 0666); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, name)
+		counts[name] = len(lines)
+	}
+	return keys, counts
+}
+
+func TestLoadFilesParallel(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys, counts := writeLogFiles(t, dir, 12)
+	var total int
+	for _, n := range counts {
+		total += n
+	}
+	args := &runArgs{
+		Parallel:      4,
+		DecodeWorkers: 2,
+		MaxSamples:    len(keys),
+		SampleRate:    1,
+		SourceColumn:  true,
+		// several batches, and so transactions, per file
+		CommitEvery: 50,
+	}
+	src := &logSource{name: "test", open: openLocalFile, stat: statLocalFile}
+	dbName := filepath.Join(dir, "test.db")
+	line := new(status.Line)
+
+	ld, err := openDatabase(ctx, args, dbName, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := loadFiles(ctx, args, ld, src, keys, line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.err(); err != nil {
+		t.Fatal(err)
+	}
+	if res.newRows != total {
+		t.Errorf("got %d new rows, want %d", res.newRows, total)
+	}
+	var done []string
+	for _, r := range res.files {
+		if r.rows != counts[r.key] {
+			t.Errorf("%s: got %d rows, want %d", r.key, r.rows, counts[r.key])
+		}
+		done = append(done, r.key)
+	}
+	slices.Sort(done)
+	if !slices.Equal(done, keys) {
+		t.Errorf("got results for %q, want %q", done, keys)
+	}
+
+	rows, err := ld.db.QueryContext(ctx, `SELECT source_key, COUNT(*) FROM logs GROUP BY 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]int)
+	for rows.Next() {
+		var key string
+		var n int
+		if err := rows.Scan(&key, &n); err != nil {
+			t.Fatal(err)
+		}
+		got[key] = n
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range counts {
+		if got[key] != want {
+			t.Errorf("%s: database has %d rows, want %d", key, got[key], want)
+		}
+	}
+	var objects, partial int
+	if err := ld.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM s3objects`).Scan(&objects); err != nil {
+		t.Fatal(err)
+	}
+	if objects != len(keys) {
+		t.Errorf("s3objects has %d rows, want %d", objects, len(keys))
+	}
+	if err := ld.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM partial_objects`).Scan(&partial); err != nil {
+		t.Fatal(err)
+	}
+	if partial != 0 {
+		t.Errorf("partial_objects has %d rows after all files were loaded", partial)
+	}
+
+	// loading the same files again adds nothing
+	res, err = loadFiles(ctx, args, ld, src, keys, line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.newRows != 0 {
+		t.Errorf("reloading added %d rows", res.newRows)
+	}
+	if err := ld.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFilesParallelKeepGoing(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys, counts := writeLogFiles(t, dir, 8)
+	broken := filepath.Join(dir, "broken.log.gz")
+	b, err := io.ReadAll(gzipLines(t, lineHTTP, `http "unterminated`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(broken, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	keys = append(keys[:4], append([]string{broken}, keys[4:]...)...)
+	var total int
+	for _, n := range counts {
+		total += n
+	}
+	args := &runArgs{
+		Parallel:      3,
+		DecodeWorkers: 2,
+		MaxSamples:    len(keys),
+		SampleRate:    1,
+		KeepGoing:     true,
+	}
+	src := &logSource{name: "test", open: openLocalFile, stat: statLocalFile}
+	line := new(status.Line)
+	ld, err := openDatabase(ctx, args, filepath.Join(dir, "test.db"), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ld.db.Close()
+	res, err := loadFiles(ctx, args, ld, src, keys, line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.failed, []string{broken}) {
+		t.Errorf("got failed files %q, want %q", res.failed, broken)
+	}
+	if len(res.files) != len(counts) {
+		t.Errorf("got %d loaded files, want %d", len(res.files), len(counts))
+	}
+	var n, objects int
+	if err := ld.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM logs`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != total || res.newRows != total {
+		t.Errorf("database has %d rows, %d reported, want %d", n, res.newRows, total)
+	}
+	if err := ld.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM s3objects`).Scan(&objects); err != nil {
+		t.Fatal(err)
+	}
+	if objects != len(counts) {
+		t.Errorf("s3objects has %d rows, want %d", objects, len(counts))
+	}
+}